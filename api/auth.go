@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// apiKeyHeader is the header API keys are accepted from.
+	apiKeyHeader = "X-Api-Key"
+
+	// subjectKey and rolesKey are the gRPC metadata keys the principal of a
+	// request is forwarded to the backends in.
+	subjectKey = "x-principal-subject"
+	rolesKey   = "x-principal-roles"
+)
+
+// AuthConfig configures how requests are authenticated. Requests without
+// credentials are served anonymously, which limits them to public data.
+type AuthConfig struct {
+	JWKSFile    string        `config:"jwks-file" usage:"JSON Web Key Set file of the public keys bearer tokens are verified with, or empty to refuse bearer tokens"`
+	Issuer      string        `config:"issuer" usage:"issuer bearer tokens must be issued by, or empty for any"`
+	Audience    string        `config:"audience" usage:"audience bearer tokens must be issued for, or empty for any"`
+	RolesClaim  string        `config:"roles-claim" usage:"claim of bearer tokens listing the roles of their subject, as a list or a space-separated string"`
+	Leeway      time.Duration `config:"leeway" usage:"clock skew allowed when checking the times of bearer tokens"`
+	APIKeysFile string        `config:"api-keys-file" usage:"YAML file of the SHA-256 hashes of API keys and the principals they authenticate, or empty to refuse API keys"`
+}
+
+// defaultAuthConfig returns the auth configuration used where nothing else is
+// set.
+func defaultAuthConfig() AuthConfig {
+	return AuthConfig{
+		RolesClaim: "roles",
+		Leeway:     time.Minute,
+	}
+}
+
+func (c *AuthConfig) Validate() error {
+	if c.JWKSFile != "" && c.RolesClaim == "" {
+		return errors.New("roles-claim is required with jwks-file")
+	}
+
+	if c.Leeway < 0 {
+		return errors.New("leeway must not be negative")
+	}
+
+	return nil
+}
+
+// signatureAlgorithms are the algorithms bearer tokens may be signed with. Only
+// asymmetric algorithms are accepted, as the keys are public.
+var signatureAlgorithms = map[jose.SignatureAlgorithm]bool{
+	jose.RS256: true, jose.RS384: true, jose.RS512: true,
+	jose.PS256: true, jose.PS384: true, jose.PS512: true,
+	jose.ES256: true, jose.ES384: true, jose.ES512: true,
+	jose.EdDSA: true,
+}
+
+// principal is who a request was made by.
+type principal struct {
+	// Subject identifies the principal, or is empty if it is anonymous.
+	Subject string `yaml:"subject"`
+
+	// Roles are the roles granted to the principal.
+	Roles []string `yaml:"roles"`
+}
+
+// apiKeysFile is the format of the API keys file, e.g.
+//
+//	keys:
+//	  - sha256: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	    subject: trading-desk
+//	    roles: [trader]
+//
+// Only hashes of the keys are kept, so that the file does not disclose them.
+type apiKeysFile struct {
+	Keys []struct {
+		SHA256    string `yaml:"sha256"`
+		principal `yaml:",inline"`
+	} `yaml:"keys"`
+}
+
+// authenticator authenticates requests by their bearer tokens or API keys.
+type authenticator struct {
+	cfg     AuthConfig
+	keys    *jose.JSONWebKeySet
+	apiKeys map[string]principal
+}
+
+// newAuthenticator loads the keys named by cfg.
+func newAuthenticator(cfg AuthConfig) (*authenticator, error) {
+	a := &authenticator{cfg: cfg}
+
+	if cfg.JWKSFile != "" {
+		keys, err := loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("jwks file %s: %w", cfg.JWKSFile, err)
+		}
+
+		a.keys = keys
+	}
+
+	if cfg.APIKeysFile != "" {
+		apiKeys, err := loadAPIKeys(cfg.APIKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("api keys file %s: %w", cfg.APIKeysFile, err)
+		}
+
+		a.apiKeys = apiKeys
+	}
+
+	return a, nil
+}
+
+func loadJWKS(path string) (*jose.JSONWebKeySet, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys jose.JSONWebKeySet
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, err
+	}
+
+	if len(keys.Keys) == 0 {
+		return nil, errors.New("no keys")
+	}
+
+	for i, key := range keys.Keys {
+		if !key.Valid() || !key.IsPublic() {
+			return nil, fmt.Errorf("key %d (%q) is not a valid public key", i, key.KeyID)
+		}
+	}
+
+	return &keys, nil
+}
+
+func loadAPIKeys(path string) (map[string]principal, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file apiKeysFile
+	if err := yaml.Unmarshal(b, &file); err != nil {
+		return nil, err
+	}
+
+	apiKeys := make(map[string]principal, len(file.Keys))
+
+	for i, key := range file.Keys {
+		hash, err := hex.DecodeString(key.SHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("key %d: sha256 must be a hex SHA-256 hash", i)
+		}
+
+		if key.Subject == "" {
+			return nil, fmt.Errorf("key %d: subject is required", i)
+		}
+
+		apiKeys[hex.EncodeToString(hash)] = key.principal
+	}
+
+	return apiKeys, nil
+}
+
+// authenticate authenticates each request, serving it as its principal, which
+// is forwarded to the backends by forwardPrincipal. Requests without
+// credentials are served anonymously, but those with invalid credentials are
+// refused.
+func (a *authenticator) authenticate(mux *runtime.ServeMux, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := a.principal(r)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"request_id": requestID(r.Context()),
+				"path":       r.URL.Path,
+			}).WithError(err).Warn("authentication failed")
+
+			writeUnauthorized(mux, w, r)
+			return
+		}
+
+		if recorded, ok := r.Context().Value(principalRecorderKey{}).(*principal); ok {
+			*recorded = p
+		}
+
+		// Credentials are not passed on, so that the backends cannot leak
+		// them.
+		r.Header.Del("Authorization")
+		r.Header.Del(apiKeyHeader)
+
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// writeUnauthorized refuses a request whose credentials are not valid. The
+// reason is only logged, so as not to help attackers. The response is written
+// as runtime.HTTPError would, but with a Bearer challenge, which HTTPError
+// replaces with the error message.
+func writeUnauthorized(mux *runtime.ServeMux, w http.ResponseWriter, r *http.Request) {
+	_, outbound := runtime.MarshalerForRequest(mux, r)
+	st := status.New(codes.Unauthenticated, "invalid credentials").Proto()
+
+	body, err := outbound.Marshal(st)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", outbound.ContentType(st))
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	w.WriteHeader(http.StatusUnauthorized)
+
+	_, _ = w.Write(body)
+}
+
+// principal returns who made a request, which is anonymous if it has no
+// credentials.
+func (a *authenticator) principal(r *http.Request) (principal, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return principal{}, errors.New("unsupported authorization scheme")
+		}
+
+		return a.verifyToken(strings.TrimSpace(token))
+	}
+
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return a.verifyAPIKey(key)
+	}
+
+	return principal{}, nil
+}
+
+func (a *authenticator) verifyToken(raw string) (principal, error) {
+	if a.keys == nil {
+		return principal{}, errors.New("bearer tokens are not accepted")
+	}
+
+	token, err := jwt.ParseSigned(raw)
+	if err != nil {
+		return principal{}, err
+	}
+
+	if len(token.Headers) != 1 {
+		return principal{}, errors.New("token must have exactly one signature")
+	}
+
+	header := token.Headers[0]
+	if !signatureAlgorithms[jose.SignatureAlgorithm(header.Algorithm)] {
+		return principal{}, fmt.Errorf("unsupported algorithm %q", header.Algorithm)
+	}
+
+	key, err := a.verificationKey(header)
+	if err != nil {
+		return principal{}, err
+	}
+
+	var (
+		claims jwt.Claims
+		extra  map[string]interface{}
+	)
+
+	if err := token.Claims(key, &claims, &extra); err != nil {
+		return principal{}, err
+	}
+
+	if claims.Expiry == nil {
+		return principal{}, errors.New("token has no expiry")
+	}
+
+	if claims.Subject == "" {
+		return principal{}, errors.New("token has no subject")
+	}
+
+	expected := jwt.Expected{Issuer: a.cfg.Issuer, Time: time.Now()}
+	if a.cfg.Audience != "" {
+		expected.Audience = jwt.Audience{a.cfg.Audience}
+	}
+
+	if err := claims.ValidateWithLeeway(expected, a.cfg.Leeway); err != nil {
+		return principal{}, err
+	}
+
+	roles, err := rolesClaim(extra[a.cfg.RolesClaim])
+	if err != nil {
+		return principal{}, fmt.Errorf("claim %s: %w", a.cfg.RolesClaim, err)
+	}
+
+	return principal{Subject: claims.Subject, Roles: roles}, nil
+}
+
+// verificationKey returns the key a token was signed with, named by its key
+// ID, which may be omitted if there is only one key.
+func (a *authenticator) verificationKey(header jose.Header) (*jose.JSONWebKey, error) {
+	var key *jose.JSONWebKey
+
+	switch {
+	case header.KeyID != "":
+		if keys := a.keys.Key(header.KeyID); len(keys) > 0 {
+			key = &keys[0]
+		}
+	case len(a.keys.Keys) == 1:
+		key = &a.keys.Keys[0]
+	}
+
+	if key == nil {
+		return nil, fmt.Errorf("unknown key %q", header.KeyID)
+	}
+
+	if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+		return nil, fmt.Errorf("key %q is not for algorithm %q", key.KeyID, header.Algorithm)
+	}
+
+	return key, nil
+}
+
+// rolesClaim parses the roles of a token, given either as a list or as a
+// space-separated string, as OAuth scopes are.
+func rolesClaim(claim interface{}) ([]string, error) {
+	switch claim := claim.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return strings.Fields(claim), nil
+	case []interface{}:
+		roles := make([]string, 0, len(claim))
+
+		for _, role := range claim {
+			s, ok := role.(string)
+			if !ok {
+				return nil, errors.New("roles must be strings")
+			}
+
+			roles = append(roles, s)
+		}
+
+		return roles, nil
+	}
+
+	return nil, errors.New("must be a list or a string")
+}
+
+func (a *authenticator) verifyAPIKey(key string) (principal, error) {
+	hash := sha256.Sum256([]byte(key))
+
+	// The lookup is by hash, so its timing reveals nothing of the keys.
+	p, ok := a.apiKeys[hex.EncodeToString(hash[:])]
+	if !ok {
+		return principal{}, errors.New("unknown api key")
+	}
+
+	return p, nil
+}
+
+// principalKey is the context key of a request's principal.
+type principalKey struct{}
+
+// principalRecorderKey is the context key of the *principal a request's
+// principal is recorded in once authenticated, so that it can be logged.
+type principalRecorderKey struct{}
+
+// withPrincipalRecorder returns a context recording the principal a request is
+// authenticated as in p.
+func withPrincipalRecorder(ctx context.Context, p *principal) context.Context {
+	return context.WithValue(ctx, principalRecorderKey{}, p)
+}
+
+// forwardPrincipal is a runtime.ServeMux metadata annotator forwarding the
+// principal of each request to the backends, which authorise it. Nothing is
+// forwarded for anonymous requests.
+func forwardPrincipal(ctx context.Context, _ *http.Request) metadata.MD {
+	p, _ := ctx.Value(principalKey{}).(principal)
+	if p.Subject == "" {
+		return nil
+	}
+
+	return metadata.Pairs(subjectKey, p.Subject, rolesKey, strings.Join(p.Roles, ","))
+}
+
+// incomingHeaderMatcher forwards headers to the backends as the default
+// matcher does, except for metadata which the gateway sets itself, so that
+// clients cannot claim to be another principal. Keys are canonicalised first,
+// as the default matcher does, so that they can't evade the check by case.
+func incomingHeaderMatcher(key string) (string, bool) {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+
+	switch strings.ToLower(strings.TrimPrefix(key, runtime.MetadataHeaderPrefix)) {
+	case subjectKey, rolesKey, requestIDKey:
+		return "", false
+	}
+
+	return runtime.DefaultHeaderMatcher(key)
+}
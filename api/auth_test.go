@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKeys are the private keys of the JWKS the test authenticator trusts, and
+// of one it doesn't.
+type testKeys struct {
+	rsa, ec, untrusted interface{}
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	untrusted, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	return testKeys{rsa: rsaKey, ec: ecKey, untrusted: untrusted}
+}
+
+// writeFile writes a file into a directory of the test's, returning its path.
+func writeFile(t *testing.T, name string, b []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, b, 0o600))
+
+	return path
+}
+
+// newTestAuthenticator returns an authenticator trusting the public keys of
+// keys, as "rsa" for RS256 and "ec" for any algorithm, and the API key "key".
+func newTestAuthenticator(t *testing.T, keys testKeys, cfg AuthConfig) *authenticator {
+	t.Helper()
+
+	jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &keys.rsa.(*rsa.PrivateKey).PublicKey, KeyID: "rsa", Algorithm: string(jose.RS256), Use: "sig"},
+		{Key: &keys.ec.(*ecdsa.PrivateKey).PublicKey, KeyID: "ec", Use: "sig"},
+	}})
+	require.NoError(t, err)
+
+	hash := sha256.Sum256([]byte("key"))
+
+	cfg.JWKSFile = writeFile(t, "jwks.json", jwks)
+	cfg.APIKeysFile = writeFile(t, "keys.yaml", []byte(`
+keys:
+  - sha256: `+hex.EncodeToString(hash[:])+`
+    subject: trading-desk
+    roles: [trader]
+`))
+
+	a, err := newAuthenticator(cfg)
+	require.NoError(t, err)
+
+	return a
+}
+
+// sign returns a compact token of the claims signed with key.
+func sign(t *testing.T, alg jose.SignatureAlgorithm, key interface{}, kid string, claims ...interface{}) string {
+	t.Helper()
+
+	opts := (&jose.SignerOptions{}).WithType("JWT")
+	if kid != "" {
+		opts = opts.WithHeader(jose.HeaderKey("kid"), kid)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, opts)
+	require.NoError(t, err)
+
+	builder := jwt.Signed(signer)
+	for _, c := range claims {
+		builder = builder.Claims(c)
+	}
+
+	token, err := builder.CompactSerialize()
+	require.NoError(t, err)
+
+	return token
+}
+
+// unsigned returns a token of the claims with the "none" algorithm.
+func unsigned(t *testing.T, claims interface{}) string {
+	t.Helper()
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+func TestVerifyToken(t *testing.T) {
+	keys := newTestKeys(t)
+	a := newTestAuthenticator(t, keys, AuthConfig{
+		Issuer:     "https://issuer.example",
+		Audience:   "racing",
+		RolesClaim: "roles",
+		Leeway:     time.Minute,
+	})
+
+	now := time.Now()
+
+	claims := func(modify func(c *jwt.Claims)) jwt.Claims {
+		c := jwt.Claims{
+			Subject:  "alice",
+			Issuer:   "https://issuer.example",
+			Audience: jwt.Audience{"racing"},
+			IssuedAt: jwt.NewNumericDate(now),
+			Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+		}
+
+		if modify != nil {
+			modify(&c)
+		}
+
+		return c
+	}
+
+	roles := func(roles interface{}) map[string]interface{} {
+		return map[string]interface{}{"roles": roles}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		token   string
+		want    principal
+		wantErr string
+	}{
+		{
+			name:  "valid",
+			token: sign(t, jose.RS256, keys.rsa, "rsa", claims(nil), roles([]string{"trader", "viewer"})),
+			want:  principal{Subject: "alice", Roles: []string{"trader", "viewer"}},
+		},
+		{
+			name:  "roles as a string",
+			token: sign(t, jose.ES256, keys.ec, "ec", claims(nil), roles("trader viewer")),
+			want:  principal{Subject: "alice", Roles: []string{"trader", "viewer"}},
+		},
+		{
+			name:  "no roles",
+			token: sign(t, jose.RS256, keys.rsa, "rsa", claims(nil)),
+			want:  principal{Subject: "alice"},
+		},
+		{
+			name:  "expired within the leeway",
+			token: sign(t, jose.RS256, keys.rsa, "rsa", claims(func(c *jwt.Claims) { c.Expiry = jwt.NewNumericDate(now.Add(-30 * time.Second)) })),
+			want:  principal{Subject: "alice"},
+		},
+		{
+			name:    "expired",
+			token:   sign(t, jose.RS256, keys.rsa, "rsa", claims(func(c *jwt.Claims) { c.Expiry = jwt.NewNumericDate(now.Add(-2 * time.Minute)) })),
+			wantErr: "expired",
+		},
+		{
+			name:    "not yet valid",
+			token:   sign(t, jose.RS256, keys.rsa, "rsa", claims(func(c *jwt.Claims) { c.NotBefore = jwt.NewNumericDate(now.Add(time.Hour)) })),
+			wantErr: "not valid yet",
+		},
+		{
+			name:    "no expiry",
+			token:   sign(t, jose.RS256, keys.rsa, "rsa", claims(func(c *jwt.Claims) { c.Expiry = nil })),
+			wantErr: "token has no expiry",
+		},
+		{
+			name:    "no subject",
+			token:   sign(t, jose.RS256, keys.rsa, "rsa", claims(func(c *jwt.Claims) { c.Subject = "" })),
+			wantErr: "token has no subject",
+		},
+		{
+			name:    "wrong issuer",
+			token:   sign(t, jose.RS256, keys.rsa, "rsa", claims(func(c *jwt.Claims) { c.Issuer = "https://other.example" })),
+			wantErr: "issuer",
+		},
+		{
+			name:    "wrong audience",
+			token:   sign(t, jose.RS256, keys.rsa, "rsa", claims(func(c *jwt.Claims) { c.Audience = jwt.Audience{"sports"} })),
+			wantErr: "audience",
+		},
+		{
+			name:    "invalid roles",
+			token:   sign(t, jose.RS256, keys.rsa, "rsa", claims(nil), roles([]interface{}{"trader", 1})),
+			wantErr: "claim roles: roles must be strings",
+		},
+		{
+			name:    "HS256",
+			token:   sign(t, jose.HS256, []byte("a shared secret of at least 32 bytes"), "rsa", claims(nil)),
+			wantErr: `unsupported algorithm "HS256"`,
+		},
+		{
+			name:  "none",
+			token: unsigned(t, claims(nil)),
+		},
+		{
+			name:    "algorithm not of the key",
+			token:   sign(t, jose.PS256, keys.rsa, "rsa", claims(nil)),
+			wantErr: `key "rsa" is not for algorithm "PS256"`,
+		},
+		{
+			name:    "unknown key",
+			token:   sign(t, jose.RS256, keys.rsa, "other", claims(nil)),
+			wantErr: `unknown key "other"`,
+		},
+		{
+			name:    "no key named with several keys",
+			token:   sign(t, jose.RS256, keys.rsa, "", claims(nil)),
+			wantErr: `unknown key ""`,
+		},
+		{
+			name:    "signed by an untrusted key",
+			token:   sign(t, jose.RS256, keys.untrusted, "rsa", claims(nil)),
+			wantErr: "error in cryptographic primitive",
+		},
+		{
+			name:  "not a token",
+			token: "not.a.token",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := a.verifyToken(tc.token)
+
+			if tc.want.Subject == "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, p)
+		})
+	}
+
+	t.Run("refused without keys", func(t *testing.T) {
+		_, err := (&authenticator{}).verifyToken(sign(t, jose.RS256, keys.rsa, "rsa", claims(nil)))
+		assert.EqualError(t, err, "bearer tokens are not accepted")
+	})
+}
+
+func TestVerifyAPIKey(t *testing.T) {
+	a := newTestAuthenticator(t, newTestKeys(t), defaultAuthConfig())
+
+	p, err := a.verifyAPIKey("key")
+	require.NoError(t, err)
+	assert.Equal(t, principal{Subject: "trading-desk", Roles: []string{"trader"}}, p)
+
+	for _, key := range []string{"Key", "key ", "other", hex.EncodeToString([]byte("key"))} {
+		_, err := a.verifyAPIKey(key)
+		assert.EqualError(t, err, "unknown api key", key)
+	}
+
+	_, err = (&authenticator{}).verifyAPIKey("key")
+	assert.EqualError(t, err, "unknown api key")
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	hash := sha256.Sum256([]byte("key"))
+
+	for name, file := range map[string]string{
+		"not hex":         "keys:\n  - sha256: key\n    subject: desk\n",
+		"short hash":      "keys:\n  - sha256: " + hex.EncodeToString(hash[:16]) + "\n    subject: desk\n",
+		"missing subject": "keys:\n  - sha256: " + hex.EncodeToString(hash[:]) + "\n",
+		"not yaml":        "keys: [",
+	} {
+		_, err := loadAPIKeys(writeFile(t, "keys.yaml", []byte(file)))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestPrincipal(t *testing.T) {
+	keys := newTestKeys(t)
+	a := newTestAuthenticator(t, keys, defaultAuthConfig())
+
+	token := sign(t, jose.RS256, keys.rsa, "rsa", jwt.Claims{
+		Subject: "alice",
+		Expiry:  jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	})
+
+	for _, tc := range []struct {
+		name    string
+		headers map[string]string
+		want    principal
+		wantErr bool
+	}{
+		{name: "anonymous"},
+		{name: "bearer token", headers: map[string]string{"Authorization": "Bearer " + token}, want: principal{Subject: "alice"}},
+		{name: "bearer scheme in lower case", headers: map[string]string{"Authorization": "bearer  " + token}, want: principal{Subject: "alice"}},
+		{name: "api key", headers: map[string]string{"X-Api-Key": "key"}, want: principal{Subject: "trading-desk", Roles: []string{"trader"}}},
+		{name: "bearer token over api key", headers: map[string]string{"Authorization": "Bearer " + token, "X-Api-Key": "key"}, want: principal{Subject: "alice"}},
+		{name: "basic", headers: map[string]string{"Authorization": "Basic YWxpY2U6c2VjcmV0"}, wantErr: true},
+		{name: "no scheme", headers: map[string]string{"Authorization": token}, wantErr: true},
+		{name: "invalid api key", headers: map[string]string{"X-Api-Key": "other"}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/v1/races/1", nil)
+			for key, value := range tc.headers {
+				r.Header.Set(key, value)
+			}
+
+			p, err := a.principal(r)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, p)
+		})
+	}
+}
+
+func TestIncomingHeaderMatcher(t *testing.T) {
+	for key, want := range map[string]string{
+		"Grpc-Metadata-X-Principal-Subject": "",
+		"Grpc-Metadata-X-Principal-Roles":   "",
+		"Grpc-Metadata-X-Request-Id":        "",
+		"grpc-metadata-x-principal-subject": "",
+		"GRPC-METADATA-X-PRINCIPAL-ROLES":   "",
+		"grpc-metadata-x-request-id":        "",
+		"X-Principal-Subject":               "",
+		"X-Request-Id":                      "",
+		"Grpc-Metadata-X-Trace":             "X-Trace",
+		"grpc-metadata-x-trace":             "X-Trace",
+		"Authorization":                     "grpcgateway-Authorization",
+		"X-Custom":                          "",
+	} {
+		got, ok := incomingHeaderMatcher(key)
+		assert.Equal(t, want != "", ok, key)
+		assert.Equal(t, want, got, key)
+	}
+}
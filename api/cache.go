@@ -0,0 +1,598 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.neds.sh/matty/entain/api/proto/racing"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/utilities"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// maxCachedRequestBody bounds the bodies read to key requests; requests
+	// with larger bodies are not cached.
+	maxCachedRequestBody = 64 << 10
+
+	// cacheWatchRetryInterval is how long the cache waits to watch races
+	// again once its stream fails, during which nothing is cached.
+	cacheWatchRetryInterval = 5 * time.Second
+
+	// cacheSubject is the subject the cache watches races as.
+	cacheSubject = "api-cache"
+)
+
+// CacheConfig configures the cache of race responses.
+type CacheConfig struct {
+	Enabled    bool          `config:"enabled" usage:"cache race responses, which keeps a watch of races open, and so the racing service polling races, for as long as the gateway runs"`
+	MaxEntries int           `config:"max-entries" usage:"responses to cache, or 0 to disable the cache"`
+	TTL        time.Duration `config:"ttl" usage:"time responses are cached for at most, in case changes to races are missed"`
+	WatchRoles []string      `config:"watch-roles" usage:"roles the cache watches races for changes with, which must see hidden races for the responses of principals with roles to be cached"`
+}
+
+// defaultCacheConfig returns the cache configuration used where nothing else
+// is set.
+func defaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		MaxEntries: 1000,
+		TTL:        time.Minute,
+		WatchRoles: []string{"trader"},
+	}
+}
+
+func (c *CacheConfig) Validate() error {
+	if c.MaxEntries < 0 {
+		return errors.New("max-entries must not be negative")
+	}
+
+	if c.Enabled && c.MaxEntries > 0 && c.TTL <= 0 {
+		return errors.New("ttl must be positive")
+	}
+
+	return nil
+}
+
+// cachedRoute is a route whose responses are cached.
+type cachedRoute struct {
+	method string
+	route  routePattern
+
+	// rpc is the RPC the route calls, whose responses are shared by the
+	// routes calling it.
+	rpc string
+
+	// request parses the RPC request of an HTTP request, whose encoding keys
+	// its response. It returns false for requests which may not be parsed,
+	// which are passed on to the mux to be refused.
+	request func(r *http.Request, params map[string]string, inbound runtime.Marshaler) (proto.Message, bool)
+}
+
+// cachedRoutes are the routes whose responses are cached, which only read
+// races.
+var cachedRoutes = []cachedRoute{
+	{
+		method: http.MethodGet,
+		route:  newRoutePattern("/v1/races"),
+		rpc:    "ListRaces",
+		request: func(r *http.Request, _ map[string]string, _ runtime.Marshaler) (proto.Message, bool) {
+			var req racing.ListRacesRequest
+			if err := runtime.PopulateQueryParameters(&req, r.URL.Query(), utilities.NewDoubleArray(nil)); err != nil {
+				return nil, false
+			}
+
+			return &req, true
+		},
+	},
+	{
+		method: http.MethodPost,
+		route:  newRoutePattern("/v1/list-races"),
+		rpc:    "ListRaces",
+		request: func(r *http.Request, _ map[string]string, inbound runtime.Marshaler) (proto.Message, bool) {
+			body, ok := peekBody(r)
+			if !ok {
+				return nil, false
+			}
+
+			var req racing.ListRacesRequest
+			if err := inbound.NewDecoder(bytes.NewReader(body)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+				return nil, false
+			}
+
+			return &req, true
+		},
+	},
+	{
+		method: http.MethodGet,
+		route:  newRoutePattern("/v1/races/{id}"),
+		rpc:    "GetRace",
+		request: func(r *http.Request, params map[string]string, _ runtime.Marshaler) (proto.Message, bool) {
+			var req racing.GetRaceRequest
+
+			var err error
+			if req.Id, err = runtime.Int64(params["id"]); err != nil {
+				return nil, false
+			}
+
+			if err := runtime.PopulateQueryParameters(&req, r.URL.Query(), utilities.NewDoubleArray([][]string{{"id"}})); err != nil {
+				return nil, false
+			}
+
+			return &req, true
+		},
+	},
+}
+
+// peekBody reads the body of a request, which is then replaced so that it may
+// be read again. It returns false if the body is too large to be cached.
+func peekBody(r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCachedRequestBody+1))
+
+	// Whatever was read is put back, followed by anything left unread.
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+	return body, err == nil && len(body) <= maxCachedRequestBody
+}
+
+// cacheEntry is a cached response.
+type cacheEntry struct {
+	key         string
+	body        []byte
+	contentType string
+	etag        string
+	expires     time.Time
+}
+
+// responseCache caches the responses of routes reading races. Responses carry
+// an ETag, so that clients may revalidate them with If-None-Match.
+//
+// The cache watches races, and is emptied whenever one changes, including when
+// its derived status changes, which the racing service reports as an update.
+// Nothing is cached while it cannot watch, as changes would be missed.
+type responseCache struct {
+	mux        *runtime.ServeMux
+	maxEntries int
+	ttl        time.Duration
+	watchRoles []string
+	lookups    *prometheus.CounterVec
+
+	mu         sync.Mutex
+	watching   bool
+	seesHidden bool
+	generation uint64
+	entries    map[string]*list.Element
+	lru        *list.List
+}
+
+// newResponseCache returns a cache of responses from the mux, or nil if cfg
+// disables it. It caches nothing until watch is run.
+func newResponseCache(cfg CacheConfig, mux *runtime.ServeMux, registry prometheus.Registerer) *responseCache {
+	if !cfg.Enabled || cfg.MaxEntries == 0 {
+		return nil
+	}
+
+	c := &responseCache{
+		mux:        mux,
+		maxEntries: cfg.MaxEntries,
+		ttl:        cfg.TTL,
+		watchRoles: cfg.WatchRoles,
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_cache_lookups_total",
+			Help: "Total number of requests to cached routes by whether they were served from the cache.",
+		}, []string{"route", "result"}),
+		seesHidden: len(cfg.WatchRoles) > 0,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+
+	registry.MustRegister(c.lookups)
+
+	return c
+}
+
+// watch watches races until ctx is done, emptying the cache whenever one
+// changes. The stream keeps the racing service polling races for as long as
+// the gateway runs, which is why the cache is opt-in, but its polls are shared
+// by every watch, so the cache of each replica adds no load beyond them.
+func (c *responseCache) watch(ctx context.Context, client racing.RacingClient) {
+	if c == nil {
+		return
+	}
+
+	if len(c.watchRoles) > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, subjectKey, cacheSubject, rolesKey, strings.Join(c.watchRoles, ","))
+	}
+
+	for {
+		err := c.watchOnce(ctx, client)
+		c.setWatching(false)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.WithError(err).Warnf("cache stopped watching races, retrying in %s", cacheWatchRetryInterval)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cacheWatchRetryInterval):
+		}
+	}
+}
+
+func (c *responseCache) watchOnce(ctx context.Context, client racing.RacingClient) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := c.checkWatchRoles(ctx, client); err != nil {
+		return err
+	}
+
+	stream, err := client.WatchRaces(ctx, &racing.WatchRacesRequest{})
+	if err != nil {
+		return err
+	}
+
+	// Caching starts once the snapshot shows the stream is established.
+	if _, err := stream.Recv(); err != nil {
+		return err
+	}
+
+	c.setWatching(true)
+
+	for {
+		if _, err := stream.Recv(); err != nil {
+			return err
+		}
+
+		c.purge()
+	}
+}
+
+// checkWatchRoles checks that the watch roles may see hidden races, which the
+// racing service only lets its trading roles do. If they may not, the watch
+// would silently miss changes to hidden races, so the mismatch is logged as an
+// error and only the responses of requests without roles are cached.
+func (c *responseCache) checkWatchRoles(ctx context.Context, client racing.RacingClient) error {
+	if len(c.watchRoles) == 0 {
+		return nil
+	}
+
+	_, err := client.ListRaces(ctx, &racing.ListRacesRequest{
+		Filter:   &racing.ListRacesRequestFilter{Visible: proto.Bool(false)},
+		PageSize: 1,
+	})
+
+	switch status.Code(err) {
+	case codes.OK:
+		c.setSeesHidden(true)
+	case codes.PermissionDenied, codes.Unauthenticated:
+		log.WithError(err).WithField("watch_roles", c.watchRoles).Error(
+			"cache watch roles may not see hidden races, so only responses of requests without roles are cached; " +
+				"set -cache.watch-roles to one of racing's -trading-roles",
+		)
+		c.setSeesHidden(false)
+	default:
+		return err
+	}
+
+	return nil
+}
+
+// setSeesHidden records whether the watch sees hidden races, and so whether
+// the responses of principals with roles may be cached.
+func (c *responseCache) setSeesHidden(seesHidden bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seesHidden = seesHidden
+}
+
+// cachesRoles reports whether the responses of principals with roles may be
+// cached.
+func (c *responseCache) cachesRoles() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.seesHidden
+}
+
+// setWatching records whether races are watched, emptying the cache either
+// way, as changes may have been missed.
+func (c *responseCache) setWatching(watching bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.watching = watching
+	c.purgeLocked()
+}
+
+// purge empties the cache.
+func (c *responseCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.purgeLocked()
+}
+
+func (c *responseCache) purgeLocked() {
+	c.generation++
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// get returns the entry cached for key, if any, and the generation of the
+// cache, which is passed to put so that responses read before a purge are not
+// cached after it.
+func (c *responseCache) get(key string, now time.Time) (*cacheEntry, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.watching {
+		return nil, c.generation, false
+	}
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, c.generation, true
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if now.After(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+
+		return nil, c.generation, true
+	}
+
+	c.lru.MoveToFront(elem)
+
+	return entry, c.generation, true
+}
+
+// put caches an entry, evicting the least recently used if the cache is full,
+// unless the cache was purged since generation.
+func (c *responseCache) put(entry *cacheEntry, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.watching || c.generation != generation {
+		return
+	}
+
+	if elem, ok := c.entries[entry.key]; ok {
+		c.lru.Remove(elem)
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// cache serves the responses of cached routes from the cache, refreshing it
+// on misses, and empties it when races are written through the gateway, so
+// that writers read their own writes without waiting on the watch. Responses
+// of cached routes carry an ETag, and are not sent again to clients which
+// already have them.
+func (c *responseCache) cache(h http.Handler) http.Handler {
+	if c == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cached, params := cachedRouteOf(r)
+		if cached == nil {
+			if r.Method != http.MethodGet && strings.HasPrefix(r.URL.Path, "/admin/") {
+				c.purgeAfter(h, w, r)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		p, _ := r.Context().Value(principalKey{}).(principal)
+
+		inbound, _ := runtime.MarshalerForRequest(c.mux, r)
+		route := cached.route.pattern
+
+		req, ok := cached.request(r, params, inbound)
+		if !ok || (len(p.Roles) > 0 && !c.cachesRoles()) {
+			// Without watching hidden races, their changes would be missed,
+			// so only responses without them are cached.
+			c.lookups.WithLabelValues(route, "bypass").Inc()
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		key, err := cacheKey(cached.rpc, req, p.Roles)
+		if err != nil {
+			c.lookups.WithLabelValues(route, "bypass").Inc()
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		now := time.Now()
+
+		entry, generation, ok := c.get(key, now)
+		if !ok {
+			c.lookups.WithLabelValues(route, "bypass").Inc()
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		if entry != nil {
+			c.lookups.WithLabelValues(route, "hit").Inc()
+
+			// Cached responses never reach the mux, so their route is
+			// recorded here.
+			setRoute(r.Context(), r.Method, route)
+			writeCached(w, r, entry, p)
+
+			return
+		}
+
+		c.lookups.WithLabelValues(route, "miss").Inc()
+
+		buffer := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		h.ServeHTTP(buffer, r)
+
+		if buffer.status != http.StatusOK {
+			buffer.writeTo(w)
+			return
+		}
+
+		entry = &cacheEntry{
+			key:         key,
+			body:        buffer.body.Bytes(),
+			contentType: buffer.header.Get("Content-Type"),
+			etag:        etag(buffer.body.Bytes()),
+			expires:     now.Add(c.ttl),
+		}
+
+		c.put(entry, generation)
+		writeCached(w, r, entry, p)
+	})
+}
+
+// cachedRouteOf returns the cached route a request is for, if any, and the
+// values of its path variables.
+func cachedRouteOf(r *http.Request) (*cachedRoute, map[string]string) {
+	for i, cached := range cachedRoutes {
+		if r.Method != cached.method {
+			continue
+		}
+
+		if params, ok := cached.route.match(r.URL.Path); ok {
+			return &cachedRoutes[i], params
+		}
+	}
+
+	return nil, nil
+}
+
+// purgeAfter serves a write, and empties the cache once it succeeds.
+func (c *responseCache) purgeAfter(h http.Handler, w http.ResponseWriter, r *http.Request) {
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	h.ServeHTTP(recorder, r)
+
+	if recorder.status < http.StatusBadRequest {
+		c.purge()
+	}
+}
+
+// cacheKey returns the key of the response to an RPC request, which is the
+// same for equal requests, whichever route they were made on and however they
+// were encoded. Responses depend on the roles of the principal, but not its
+// identity.
+func cacheKey(rpc string, req proto.Message, roles []string) (string, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	sorted := append([]string(nil), roles...)
+	sort.Strings(sorted)
+
+	return rpc + "\x00" + strings.Join(sorted, ",") + "\x00" + string(b), nil
+}
+
+// etag returns the strong ETag of a response body.
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// writeCached writes a cached response, or 304 if the client already has it.
+func writeCached(w http.ResponseWriter, r *http.Request, entry *cacheEntry, p principal) {
+	header := w.Header()
+	header.Set("ETag", entry.etag)
+	header.Add("Vary", "Authorization, X-Api-Key")
+
+	// Responses may change at any time, so clients must revalidate them, and
+	// those of principals must not be shared.
+	if p.Subject != "" {
+		header.Set("Cache-Control", "private, no-cache")
+	} else {
+		header.Set("Cache-Control", "public, no-cache")
+	}
+
+	if etagMatches(r.Header.Get("If-None-Match"), entry.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Type", entry.contentType)
+	header.Set("Content-Length", strconv.Itoa(len(entry.body)))
+	w.WriteHeader(http.StatusOK)
+
+	_, _ = w.Write(entry.body)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing
+// weakly as RFC 9110 requires.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bufferedResponse buffers a response, so that it may be cached before it is
+// written.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+// writeTo writes the buffered response.
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	w.WriteHeader(b.status)
+	_, _ = w.Write(b.body.Bytes())
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/api/proto/racing"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// countingBackend stands in for the mux behind the cache, answering each
+// request with the number of requests it has served, so that responses from
+// the cache can be told apart.
+type countingBackend struct {
+	mu     sync.Mutex
+	served int
+	status int
+}
+
+func (b *countingBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	b.served++
+	served := b.served
+	b.mu.Unlock()
+
+	status := b.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, `{"served":%d}`, served)
+}
+
+func (b *countingBackend) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.served
+}
+
+// testCacheConfig returns the default cache configuration, enabled.
+func testCacheConfig() CacheConfig {
+	cfg := defaultCacheConfig()
+	cfg.Enabled = true
+
+	return cfg
+}
+
+// newTestCache returns a cache in front of a counting backend, which caches as
+// though it were watching races.
+func newTestCache(t *testing.T, cfg CacheConfig) (*responseCache, *countingBackend, http.Handler) {
+	t.Helper()
+
+	c := newResponseCache(cfg, runtime.NewServeMux(), prometheus.NewRegistry())
+	require.NotNil(t, c)
+
+	c.setWatching(true)
+
+	backend := &countingBackend{}
+
+	return c, backend, c.cache(backend)
+}
+
+// cacheRequest describes a request to the cache.
+type cacheRequest struct {
+	method      string
+	path        string
+	body        string
+	principal   principal
+	ifNoneMatch string
+}
+
+func (req cacheRequest) serve(h http.Handler) *httptest.ResponseRecorder {
+	method := req.method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var body io.Reader
+	if req.body != "" {
+		body = strings.NewReader(req.body)
+	}
+
+	r := httptest.NewRequest(method, req.path, body)
+	if req.body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+
+	if req.ifNoneMatch != "" {
+		r.Header.Set("If-None-Match", req.ifNoneMatch)
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), principalKey{}, req.principal))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestResponseCacheETag(t *testing.T) {
+	_, backend, h := newTestCache(t, testCacheConfig())
+
+	req := cacheRequest{path: "/v1/races/1"}
+
+	w := req.serve(h)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"served":1}`, w.Body.String())
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "public, no-cache", w.Header().Get("Cache-Control"))
+	assert.Equal(t, etag([]byte(`{"served":1}`)), w.Header().Get("ETag"))
+
+	tag := w.Header().Get("ETag")
+
+	// The response is served from the cache.
+	w = req.serve(h)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"served":1}`, w.Body.String())
+	assert.Equal(t, tag, w.Header().Get("ETag"))
+	assert.Equal(t, 1, backend.count())
+
+	// Clients which have it are not sent it again.
+	for _, ifNoneMatch := range []string{tag, "W/" + tag, `"other", ` + tag, "*"} {
+		req.ifNoneMatch = ifNoneMatch
+
+		w = req.serve(h)
+		assert.Equal(t, http.StatusNotModified, w.Code, ifNoneMatch)
+		assert.Empty(t, w.Body.String(), ifNoneMatch)
+		assert.Equal(t, tag, w.Header().Get("ETag"), ifNoneMatch)
+	}
+
+	req.ifNoneMatch = `"other"`
+
+	w = req.serve(h)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"served":1}`, w.Body.String())
+
+	// Even a client revalidating its first request is sent a 304.
+	w = cacheRequest{path: "/v1/races/2", ifNoneMatch: etag([]byte(`{"served":2}`))}.serve(h)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, 2, backend.count())
+
+	// The responses of principals are private.
+	w = cacheRequest{path: "/v1/races/1", principal: principal{Subject: "alice"}}.serve(h)
+	assert.Equal(t, "private, no-cache", w.Header().Get("Cache-Control"))
+}
+
+func TestResponseCacheKeys(t *testing.T) {
+	_, backend, h := newTestCache(t, testCacheConfig())
+
+	trader := principal{Subject: "alice", Roles: []string{"trader", "viewer"}}
+
+	for _, step := range []struct {
+		name string
+		req  cacheRequest
+		want int
+	}{
+		{name: "list", req: cacheRequest{path: "/v1/races?filter.meeting_ids=1"}, want: 1},
+		{name: "list again", req: cacheRequest{path: "/v1/races?filter.meeting_ids=1"}, want: 1},
+		{
+			name: "list by post",
+			req:  cacheRequest{method: http.MethodPost, path: "/v1/list-races", body: `{"filter": {"meetingIds": ["1"]}}`},
+			want: 1,
+		},
+		{name: "other filter", req: cacheRequest{path: "/v1/races?filter.meeting_ids=2"}, want: 2},
+		{name: "subject without roles", req: cacheRequest{path: "/v1/races?filter.meeting_ids=1", principal: principal{Subject: "bob"}}, want: 2},
+		{name: "roles", req: cacheRequest{path: "/v1/races?filter.meeting_ids=1", principal: trader}, want: 3},
+		{
+			name: "roles in another order of another principal",
+			req:  cacheRequest{path: "/v1/races?filter.meeting_ids=1", principal: principal{Subject: "carol", Roles: []string{"viewer", "trader"}}},
+			want: 3,
+		},
+		{name: "other roles", req: cacheRequest{path: "/v1/races?filter.meeting_ids=1", principal: principal{Subject: "dave", Roles: []string{"viewer"}}}, want: 4},
+		{name: "get", req: cacheRequest{path: "/v1/races/1"}, want: 5},
+		{name: "get with view", req: cacheRequest{path: "/v1/races/1?view=RACE_VIEW_FULL"}, want: 6},
+		{name: "uncached route", req: cacheRequest{path: "/v1/meetings"}, want: 7},
+		{name: "uncached route again", req: cacheRequest{path: "/v1/meetings"}, want: 8},
+		{name: "unparsable request", req: cacheRequest{path: "/v1/races/one"}, want: 9},
+		{name: "unparsable request again", req: cacheRequest{path: "/v1/races/one"}, want: 10},
+	} {
+		w := step.req.serve(h)
+		assert.Equal(t, http.StatusOK, w.Code, step.name)
+		assert.Equal(t, step.want, backend.count(), step.name)
+	}
+}
+
+func TestResponseCacheWithoutWatchRoles(t *testing.T) {
+	cfg := testCacheConfig()
+	cfg.WatchRoles = nil
+
+	_, backend, h := newTestCache(t, cfg)
+
+	// Changes to hidden races would be missed, so only the responses of
+	// requests without roles are cached.
+	trader := cacheRequest{path: "/v1/races", principal: principal{Subject: "alice", Roles: []string{"trader"}}}
+	trader.serve(h)
+	trader.serve(h)
+	assert.Equal(t, 2, backend.count())
+
+	anonymous := cacheRequest{path: "/v1/races"}
+	anonymous.serve(h)
+	anonymous.serve(h)
+	assert.Equal(t, 3, backend.count())
+}
+
+func TestResponseCacheUncachedResponses(t *testing.T) {
+	_, backend, h := newTestCache(t, testCacheConfig())
+	backend.status = http.StatusNotFound
+
+	req := cacheRequest{path: "/v1/races/1"}
+
+	w := req.serve(h)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, `{"served":1}`, w.Body.String())
+	assert.Empty(t, w.Header().Get("ETag"))
+
+	req.serve(h)
+	assert.Equal(t, 2, backend.count())
+}
+
+func TestResponseCachePurgedByWrites(t *testing.T) {
+	_, backend, h := newTestCache(t, testCacheConfig())
+
+	read := cacheRequest{path: "/v1/races/1"}
+	read.serve(h)
+
+	for _, step := range []struct {
+		name       string
+		req        cacheRequest
+		status     int
+		wantPurged bool
+	}{
+		{name: "create", req: cacheRequest{method: http.MethodPost, path: "/admin/v1/races", body: "{}"}, wantPurged: true},
+		{name: "update", req: cacheRequest{method: http.MethodPatch, path: "/admin/v1/races/1", body: "{}"}, wantPurged: true},
+		{name: "delete", req: cacheRequest{method: http.MethodDelete, path: "/admin/v1/races/1"}, wantPurged: true},
+		{name: "failed write", req: cacheRequest{method: http.MethodDelete, path: "/admin/v1/races/1"}, status: http.StatusNotFound},
+		{name: "read", req: cacheRequest{path: "/admin/v1/races/1"}},
+		{name: "other write", req: cacheRequest{method: http.MethodPost, path: "/v1/list-meetings", body: "{}"}},
+	} {
+		backend.status = step.status
+		step.req.serve(h)
+		backend.status = 0
+
+		before := backend.count()
+		read.serve(h)
+
+		purged := backend.count() > before
+		assert.Equal(t, step.wantPurged, purged, step.name)
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	c := newResponseCache(CacheConfig{Enabled: true, MaxEntries: 2, TTL: time.Minute}, runtime.NewServeMux(), prometheus.NewRegistry())
+	c.setWatching(true)
+
+	now := time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	put := func(key string) {
+		_, generation, ok := c.get(key, now)
+		require.True(t, ok)
+
+		c.put(&cacheEntry{key: key, expires: now.Add(c.ttl)}, generation)
+	}
+
+	cached := func(key string) bool {
+		entry, _, ok := c.get(key, now)
+		require.True(t, ok)
+
+		return entry != nil
+	}
+
+	// The least recently used entry is evicted once the cache is full.
+	put("a")
+	put("b")
+	assert.True(t, cached("a"))
+
+	put("c")
+	assert.True(t, cached("a"))
+	assert.False(t, cached("b"))
+	assert.True(t, cached("c"))
+
+	// Entries expire after the TTL.
+	now = now.Add(c.ttl)
+	assert.True(t, cached("a"))
+
+	now = now.Add(time.Nanosecond)
+	assert.False(t, cached("a"))
+	assert.Equal(t, 1, c.lru.Len())
+
+	// Responses read before a purge are not cached after it.
+	_, generation, _ := c.get("d", now)
+	c.purge()
+	c.put(&cacheEntry{key: "d", expires: now.Add(c.ttl)}, generation)
+	assert.False(t, cached("d"))
+}
+
+// fakeWatchClient serves one WatchRaces stream, which receives the responses
+// and errors sent on events. Listing hidden races is refused if denied.
+type fakeWatchClient struct {
+	racing.RacingClient
+	denied bool
+
+	// md is the metadata the stream was opened with.
+	md metadata.MD
+
+	// waiting receives whenever the watcher waits to receive a response.
+	waiting chan struct{}
+	events  chan error
+}
+
+func (c *fakeWatchClient) ListRaces(context.Context, *racing.ListRacesRequest, ...grpc.CallOption) (*racing.ListRacesResponse, error) {
+	if c.denied {
+		return nil, status.Error(codes.PermissionDenied, "not authorised")
+	}
+
+	return &racing.ListRacesResponse{}, nil
+}
+
+func (c *fakeWatchClient) WatchRaces(ctx context.Context, _ *racing.WatchRacesRequest, _ ...grpc.CallOption) (racing.Racing_WatchRacesClient, error) {
+	c.md, _ = metadata.FromOutgoingContext(ctx)
+	return &fakeWatchStream{ctx: ctx, client: c}, nil
+}
+
+type fakeWatchStream struct {
+	grpc.ClientStream
+	ctx    context.Context
+	client *fakeWatchClient
+}
+
+func (s *fakeWatchStream) Recv() (*racing.WatchRacesResponse, error) {
+	select {
+	case s.client.waiting <- struct{}{}:
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+
+	select {
+	case err := <-s.client.events:
+		if err != nil {
+			return nil, err
+		}
+
+		return &racing.WatchRacesResponse{Type: racing.WatchRacesResponse_UPDATED}, nil
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+// isWatching reports whether the cache is watching races.
+func isWatching(c *responseCache) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.watching
+}
+
+// startWatch runs the watch of a cache until the test ends.
+func startWatch(t *testing.T, c *responseCache, client racing.RacingClient) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		c.watch(ctx, client)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestResponseCacheDisabled(t *testing.T) {
+	assert.Nil(t, newResponseCache(defaultCacheConfig(), runtime.NewServeMux(), prometheus.NewRegistry()))
+
+	cfg := testCacheConfig()
+	cfg.MaxEntries = 0
+	assert.Nil(t, newResponseCache(cfg, runtime.NewServeMux(), prometheus.NewRegistry()))
+}
+
+func TestResponseCacheWatch(t *testing.T) {
+	c := newResponseCache(testCacheConfig(), runtime.NewServeMux(), prometheus.NewRegistry())
+	backend := &countingBackend{}
+	h := c.cache(backend)
+
+	client := &fakeWatchClient{waiting: make(chan struct{}), events: make(chan error)}
+	startWatch(t, c, client)
+
+	read := cacheRequest{path: "/v1/races/1"}
+
+	// Nothing is cached until the snapshot shows the stream is established.
+	<-client.waiting
+	assert.Equal(t, []string{cacheSubject}, client.md.Get(subjectKey))
+	assert.Equal(t, []string{"trader"}, client.md.Get(rolesKey))
+
+	read.serve(h)
+	read.serve(h)
+	assert.Equal(t, 2, backend.count())
+
+	client.events <- nil
+	<-client.waiting
+
+	read.serve(h)
+	read.serve(h)
+	assert.Equal(t, 3, backend.count())
+
+	// Changes to races empty the cache.
+	client.events <- nil
+	<-client.waiting
+
+	read.serve(h)
+	read.serve(h)
+	assert.Equal(t, 4, backend.count())
+
+	// Nothing is cached while the watch is down.
+	client.events <- io.ErrUnexpectedEOF
+	require.Eventually(t, func() bool { return !isWatching(c) }, time.Second, time.Millisecond)
+
+	read.serve(h)
+	read.serve(h)
+	assert.Equal(t, 6, backend.count())
+}
+
+func TestResponseCacheWatchRolesDenied(t *testing.T) {
+	c := newResponseCache(testCacheConfig(), runtime.NewServeMux(), prometheus.NewRegistry())
+	backend := &countingBackend{}
+	h := c.cache(backend)
+
+	client := &fakeWatchClient{denied: true, waiting: make(chan struct{}), events: make(chan error)}
+	startWatch(t, c, client)
+
+	// The snapshot is received, and the one after waited on.
+	<-client.waiting
+	client.events <- nil
+	<-client.waiting
+
+	require.True(t, isWatching(c))
+
+	// The watch would miss changes to hidden races, so only the responses of
+	// requests without roles are cached.
+	trader := cacheRequest{path: "/v1/races", principal: principal{Subject: "alice", Roles: []string{"trader"}}}
+	trader.serve(h)
+	trader.serve(h)
+	assert.Equal(t, 2, backend.count())
+
+	anonymous := cacheRequest{path: "/v1/races"}
+	anonymous.serve(h)
+	anonymous.serve(h)
+	assert.Equal(t, 3, backend.count())
+}
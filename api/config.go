@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"git.neds.sh/matty/entain/config"
+)
+
+// Config is the configuration of the API gateway. See config.Load for how it
+// is set.
+type Config struct {
+	APIEndpoint       string          `config:"api-endpoint" usage:"API endpoint"`
+	TLS               config.TLS      `config:"tls"`
+	GRPCEndpoint      string          `config:"grpc-endpoint" usage:"gRPC server endpoint"`
+	GRPCTLS           config.TLS      `config:"grpc-tls"`
+	SportsEndpoint    string          `config:"sports-endpoint" usage:"Sports gRPC server endpoint"`
+	SportsGRPCTLS     config.TLS      `config:"sports-grpc-tls"`
+	ReadHeaderTimeout time.Duration   `config:"read-header-timeout" usage:"time allowed to read request headers"`
+	RequestTimeout    time.Duration   `config:"request-timeout" usage:"time each request may take, other than watch-races streams, or 0 for none"`
+	LogLevel          string          `config:"log-level" usage:"minimum level of logs to write: debug, info, warn or error"`
+	LogFormat         string          `config:"log-format" usage:"format of logs: text or json"`
+	DrainTimeout      time.Duration   `config:"drain-timeout" usage:"time in-flight requests may take to finish on shutdown"`
+	MetricsEndpoint   string          `config:"metrics-endpoint" usage:"endpoint serving Prometheus metrics on /metrics, or empty to disable them"`
+	Tracing           config.Tracing  `config:"tracing"`
+	Auth              AuthConfig      `config:"auth"`
+	RateLimit         RateLimitConfig `config:"rate-limit"`
+	Cache             CacheConfig     `config:"cache"`
+}
+
+// defaultConfig returns the configuration used where nothing else is set.
+func defaultConfig() Config {
+	return Config{
+		APIEndpoint:       "localhost:8000",
+		GRPCEndpoint:      "localhost:9000",
+		SportsEndpoint:    "localhost:9001",
+		ReadHeaderTimeout: 10 * time.Second,
+		RequestTimeout:    30 * time.Second,
+		LogLevel:          "info",
+		LogFormat:         "text",
+		DrainTimeout:      15 * time.Second,
+		MetricsEndpoint:   "localhost:8100",
+		Tracing:           config.DefaultTracing(),
+		Auth:              defaultAuthConfig(),
+		Cache:             defaultCacheConfig(),
+	}
+}
+
+func (c *Config) Validate() error {
+	for name, endpoint := range map[string]string{
+		"api-endpoint":    c.APIEndpoint,
+		"grpc-endpoint":   c.GRPCEndpoint,
+		"sports-endpoint": c.SportsEndpoint,
+	} {
+		if endpoint == "" {
+			return fmt.Errorf("%s is required", name)
+		}
+	}
+
+	if err := c.TLS.ValidateServer(); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+
+	if err := c.GRPCTLS.ValidateClient(); err != nil {
+		return fmt.Errorf("grpc-tls: %w", err)
+	}
+
+	if err := c.SportsGRPCTLS.ValidateClient(); err != nil {
+		return fmt.Errorf("sports-grpc-tls: %w", err)
+	}
+
+	if err := c.Tracing.Validate(); err != nil {
+		return fmt.Errorf("tracing: %w", err)
+	}
+
+	if err := c.Auth.Validate(); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+
+	if err := c.RateLimit.Validate(); err != nil {
+		return fmt.Errorf("rate-limit: %w", err)
+	}
+
+	if err := c.Cache.Validate(); err != nil {
+		return fmt.Errorf("cache: %w", err)
+	}
+
+	if c.ReadHeaderTimeout < 0 || c.RequestTimeout < 0 || c.DrainTimeout < 0 {
+		return errors.New("timeouts must not be negative")
+	}
+
+	if err := config.ValidateLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+
+	return config.ValidateLogFormat(c.LogFormat)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"git.neds.sh/matty/entain/api/docs"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+)
+
+const (
+	// specPath is the path the OpenAPI document of the racing API is served
+	// on.
+	specPath = "/openapi.json"
+
+	// docsPath is the path the Swagger UI rendering the document is served
+	// under.
+	docsPath = "/docs"
+)
+
+// specETag identifies the version of the document, which only changes with
+// the gateway.
+var specETag = etag(docs.Spec)
+
+// specHandler serves the OpenAPI document. Clients must revalidate it, as it
+// changes when the gateway is upgraded.
+func specHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("ETag", specETag)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	http.ServeContent(w, r, specPath, time.Time{}, bytes.NewReader(docs.Spec))
+}
+
+// docsHandler serves the files of the Swagger UI under docsPath. The UI is
+// served from docsPath/, so that it loads its files relative to it.
+func docsHandler() runtime.HandlerFunc {
+	files := http.StripPrefix(docsPath, http.FileServer(http.FS(docs.UI)))
+
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		if r.URL.Path == docsPath {
+			http.Redirect(w, r, docsPath+"/", http.StatusMovedPermanently)
+			return
+		}
+
+		files.ServeHTTP(w, r)
+	}
+}
+
+// handleDocs adds the routes serving the OpenAPI document and its UI to mux.
+func handleDocs(mux *runtime.ServeMux) error {
+	if err := mux.HandlePath(http.MethodGet, specPath, withRoute(specPath, specHandler)); err != nil {
+		return err
+	}
+
+	ui := docsHandler()
+
+	if err := mux.HandlePath(http.MethodGet, docsPath, withRoute(docsPath, ui)); err != nil {
+		return err
+	}
+
+	return mux.HandlePath(http.MethodGet, docsPath+"/{file}", withRoute(docsPath+"/{file}", ui))
+}
@@ -0,0 +1,37 @@
+// Package docs holds the OpenAPI document of the racing API, generated from its
+// protos, and a Swagger UI rendering it, which the gateway serves so that
+// clients can browse the API and try it out.
+package docs
+
+import (
+	"embed"
+	"io/fs"
+
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+//go:generate protoc -I ../proto --openapiv2_out . --openapiv2_opt allow_merge=true,merge_file_name=racing,openapi_configuration=openapi.yaml racing/racing.proto
+
+// Spec is the OpenAPI v2 document of the racing API.
+//
+//go:embed racing.swagger.json
+var Spec []byte
+
+// initializer holds the script configuring the Swagger UI, which replaces the
+// one bundled with it.
+//
+//go:embed swagger-initializer.js
+var initializer embed.FS
+
+// UI is the Swagger UI, rendering the document served on /openapi.json.
+var UI fs.FS = ui{}
+
+type ui struct{}
+
+func (ui) Open(name string) (fs.File, error) {
+	if name == "swagger-initializer.js" {
+		return initializer.Open(name)
+	}
+
+	return swaggerFiles.FS.Open(name)
+}
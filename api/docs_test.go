@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"git.neds.sh/matty/entain/api/docs"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleDocs(t *testing.T) {
+	mux := runtime.NewServeMux()
+	require.NoError(t, handleDocs(mux))
+
+	get := func(target string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("spec", func(t *testing.T) {
+		w := get(specPath, nil)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+		assert.Equal(t, docs.Spec, w.Body.Bytes())
+
+		var spec struct {
+			Swagger string                     `json:"swagger"`
+			Paths   map[string]json.RawMessage `json:"paths"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &spec))
+		assert.Equal(t, "2.0", spec.Swagger)
+		assert.Contains(t, spec.Paths, "/v1/races")
+
+		// Clients which already have the document are not sent it again.
+		w = get(specPath, http.Header{"If-None-Match": {w.Header().Get("ETag")}})
+		assert.Equal(t, http.StatusNotModified, w.Code)
+	})
+
+	t.Run("ui", func(t *testing.T) {
+		w := get(docsPath, nil)
+		assert.Equal(t, http.StatusMovedPermanently, w.Code)
+		assert.Equal(t, docsPath+"/", w.Header().Get("Location"))
+
+		w = get(docsPath+"/", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+		assert.Contains(t, w.Body.String(), "swagger-ui")
+
+		// The UI is configured to render the document served by the gateway.
+		w = get(docsPath+"/swagger-initializer.js", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), specPath)
+
+		assert.Equal(t, http.StatusNotFound, get(docsPath+"/missing.js", nil).Code)
+	})
+}
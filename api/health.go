@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"git.neds.sh/matty/entain/api/proto/racing"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// readinessProbeTimeout bounds how long /readyz waits on the backend.
+const readinessProbeTimeout = 2 * time.Second
+
+// healthzHandler reports that the gateway is alive. It does not probe the
+// backend, so that a backend outage does not get the gateway restarted.
+func healthzHandler(w http.ResponseWriter, _ *http.Request, _ map[string]string) {
+	writeHealth(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyzHandler reports whether the gateway can serve requests: it is not
+// draining, and the racing service reports itself SERVING over gRPC health
+// checking.
+func readyzHandler(client grpc_health_v1.HealthClient, draining *atomic.Bool) func(http.ResponseWriter, *http.Request, map[string]string) {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		if draining.Load() {
+			writeHealth(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), readinessProbeTimeout)
+		defer cancel()
+
+		status := grpc_health_v1.HealthCheckResponse_UNKNOWN.String()
+
+		resp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{
+			Service: racing.Racing_ServiceDesc.ServiceName,
+		})
+		if err == nil {
+			status = resp.Status.String()
+		}
+
+		if err != nil || resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+			writeHealth(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "racing": status})
+			return
+		}
+
+		writeHealth(w, http.StatusOK, map[string]string{"status": "ok", "racing": status})
+	}
+}
+
+func writeHealth(w http.ResponseWriter, code int, body map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+
+	_ = json.NewEncoder(w).Encode(body)
+}
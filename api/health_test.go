@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// fakeHealthClient answers health checks with a fixed status, or error.
+type fakeHealthClient struct {
+	grpc_health_v1.HealthClient
+	status grpc_health_v1.HealthCheckResponse_ServingStatus
+	err    error
+	checks int
+}
+
+func (c *fakeHealthClient) Check(context.Context, *grpc_health_v1.HealthCheckRequest, ...grpc.CallOption) (*grpc_health_v1.HealthCheckResponse, error) {
+	c.checks++
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return &grpc_health_v1.HealthCheckResponse{Status: c.status}, nil
+}
+
+func TestHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	healthzHandler(w, httptest.NewRequest(http.MethodGet, "/healthz", nil), nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+}
+
+func TestReadyz(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		client   *fakeHealthClient
+		draining bool
+		wantCode int
+		wantBody map[string]string
+	}{
+		{
+			name:     "serving",
+			client:   &fakeHealthClient{status: grpc_health_v1.HealthCheckResponse_SERVING},
+			wantCode: http.StatusOK,
+			wantBody: map[string]string{"status": "ok", "racing": "SERVING"},
+		},
+		{
+			name:     "not serving",
+			client:   &fakeHealthClient{status: grpc_health_v1.HealthCheckResponse_NOT_SERVING},
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: map[string]string{"status": "unavailable", "racing": "NOT_SERVING"},
+		},
+		{
+			name:     "unreachable",
+			client:   &fakeHealthClient{err: errors.New("connection refused")},
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: map[string]string{"status": "unavailable", "racing": "UNKNOWN"},
+		},
+		{
+			name:     "draining",
+			client:   &fakeHealthClient{status: grpc_health_v1.HealthCheckResponse_SERVING},
+			draining: true,
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: map[string]string{"status": "draining"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var draining atomic.Bool
+			draining.Store(tc.draining)
+
+			w := httptest.NewRecorder()
+			readyzHandler(tc.client, &draining)(w, httptest.NewRequest(http.MethodGet, "/readyz", nil), nil)
+
+			assert.Equal(t, tc.wantCode, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+			var body map[string]string
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, tc.wantBody, body)
+
+			// A draining gateway doesn't probe the backend.
+			assert.Equal(t, !tc.draining, tc.client.checks > 0)
+		})
+	}
+}
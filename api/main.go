@@ -2,45 +2,277 @@ package main
 
 import (
 	"context"
-	"flag"
-	"log"
+	"errors"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"git.neds.sh/matty/entain/api/proto/racing"
+	"git.neds.sh/matty/entain/api/proto/sports"
+	"git.neds.sh/matty/entain/api/ratelimit"
+	"git.neds.sh/matty/entain/config"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	// Registers error detail types, such as BadRequest, so the gateway can
+	// render them in error responses.
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/credentials/insecure"
-)
-
-var (
-	apiEndpoint  = flag.String("api-endpoint", "localhost:8000", "API endpoint")
-	grpcEndpoint = flag.String("grpc-endpoint", "localhost:9000", "gRPC server endpoint")
+	"google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
-	flag.Parse()
+	cfg := defaultConfig()
+
+	if _, err := config.Load("api", os.Args[1:], &cfg); err != nil {
+		if config.IsHelp(err) {
+			return
+		}
 
-	if err := run(); err != nil {
-		log.Printf("failed running api server: %s\n", err)
+		log.Fatalf("failed loading config: %s", err)
+	}
+
+	if err := config.SetupLogging(cfg.LogLevel, cfg.LogFormat); err != nil {
+		log.Fatalf("failed setting up logging: %s", err)
+	}
+
+	if err := run(cfg); err != nil {
+		log.Errorf("failed running api server: %s", err)
 	}
 }
 
-func run() error {
+func run(cfg Config) error {
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	mux := runtime.NewServeMux()
-	if err := racing.RegisterRacingHandlerFromEndpoint(
+	// Signals are watched on a separate context, as cancelling ctx would close
+	// the backend connections while requests drain.
+	sigCtx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	shutdownTracing, err := cfg.Tracing.Start(ctx, "api")
+	if err != nil {
+		return err
+	}
+	defer config.FlushTracing(shutdownTracing)
+
+	serverTLS, err := cfg.TLS.ServerConfig()
+	if err != nil {
+		return err
+	}
+
+	racingDialOpts, err := backendDialOptions(cfg.GRPCTLS)
+	if err != nil {
+		return err
+	}
+
+	// Sports is served separately, so is connected to with TLS settings of
+	// its own.
+	sportsDialOpts, err := backendDialOptions(cfg.SportsGRPCTLS)
+	if err != nil {
+		return err
+	}
+
+	racingConn, err := grpc.DialContext(ctx, cfg.GRPCEndpoint, racingDialOpts...)
+	if err != nil {
+		return err
+	}
+	defer racingConn.Close()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	httpMetrics := newHTTPMetrics(registry)
+
+	authn, err := newAuthenticator(cfg.Auth)
+	if err != nil {
+		return err
+	}
+
+	limiter, err := newRateLimiter(cfg.RateLimit, ratelimit.NewMemoryStore())
+	if err != nil {
+		return err
+	}
+
+	mux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(incomingHeaderMatcher),
+		runtime.WithMetadata(recordRoute),
+		runtime.WithMetadata(forwardRequestID),
+		runtime.WithMetadata(forwardPrincipal),
+	)
+	if err := racing.RegisterRacingHandler(ctx, mux, racingConn); err != nil {
+		return err
+	}
+
+	// Cached responses are dropped whenever a race changes, which is watched
+	// for in the background.
+	cache := newResponseCache(cfg.Cache, mux, registry)
+	go cache.watch(ctx, racing.NewRacingClient(racingConn))
+
+	// WatchRaces has no HTTP binding; it is served as Server-Sent Events.
+	if err := mux.HandlePath(
+		http.MethodGet,
+		watchRacesPath,
+		withRoute(watchRacesPath, watchRacesHandler(mux, racing.NewRacingClient(racingConn))),
+	); err != nil {
+		return err
+	}
+
+	var draining atomic.Bool
+
+	if err := mux.HandlePath(http.MethodGet, "/healthz", withRoute("/healthz", healthzHandler)); err != nil {
+		return err
+	}
+
+	if err := mux.HandlePath(
+		http.MethodGet,
+		"/readyz",
+		withRoute("/readyz", readyzHandler(grpc_health_v1.NewHealthClient(racingConn), &draining)),
+	); err != nil {
+		return err
+	}
+
+	if err := handleDocs(mux); err != nil {
+		return err
+	}
+
+	if err := sports.RegisterSportsHandlerFromEndpoint(
 		ctx,
 		mux,
-		*grpcEndpoint,
-		[]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+		cfg.SportsEndpoint,
+		sportsDialOpts,
 	); err != nil {
 		return err
 	}
 
-	log.Printf("API server listening on: %s\n", *apiEndpoint)
+	// Handlers are listed innermost first. Requests are authenticated before
+	// they are limited, so that clients are limited by principal, and limited
+	// before they are served from the cache. Credentials are limited before
+	// they are verified, so that failed attempts are limited too.
+	var handler http.Handler = withRequestTimeout(mux, cfg.RequestTimeout)
+	handler = cache.cache(handler)
+	handler = limiter.limit(mux, handler)
+	handler = authn.authenticate(mux, handler)
+	handler = limiter.limitCredentials(mux, handler)
+	handler = httpMetrics.instrument(handler)
+	handler = withRequestID(handler)
+	handler = withTracing(handler)
+
+	server := &http.Server{
+		Addr:              cfg.APIEndpoint,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		TLSConfig:         serverTLS,
+	}
+
+	metricsServer, err := config.ServeMetrics(cfg.MetricsEndpoint, registry)
+	if err != nil {
+		return err
+	}
+
+	// Metrics are served until the drain completes, so that it can be
+	// observed.
+	if metricsServer != nil {
+		defer metricsServer.Close()
+	}
+
+	log.Infof("API server listening on: %s", cfg.APIEndpoint)
+
+	served := make(chan error, 1)
+
+	go func() {
+		if serverTLS != nil {
+			served <- server.ListenAndServeTLS("", "")
+			return
+		}
+
+		served <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-sigCtx.Done():
+	}
+
+	log.WithField("drain_timeout", cfg.DrainTimeout.String()).Info("shutting down, draining requests")
+
+	draining.Store(true)
+
+	drainCtx, cancelDrain := context.WithTimeout(ctx, cfg.DrainTimeout)
+	defer cancelDrain()
+
+	// Event streams never go idle, so are closed once the drain times out.
+	if err := server.Shutdown(drainCtx); errors.Is(err, context.DeadlineExceeded) {
+		log.Warn("drain timed out, closing remaining connections")
+		return server.Close()
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// backendDialOptions returns the options to dial a backend with, over TLS if
+// it is enabled. Trace context is propagated to the backend, so that its spans
+// join the request's trace.
+func backendDialOptions(t config.TLS) ([]grpc.DialOption, error) {
+	clientTLS, err := t.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	creds := insecure.NewCredentials()
+	if clientTLS != nil {
+		creds = credentials.NewTLS(clientTLS)
+	}
+
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor()),
+	}, nil
+}
+
+// withRequestTimeout bounds the time each request may take, which the gateway
+// forwards to the backends as a gRPC deadline. Event streams are exempt, as
+// they are expected to stay open.
+func withRequestTimeout(h http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == watchRacesPath {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-	return http.ListenAndServe(*apiEndpoint, mux)
+// withTracing starts a span for each request, continuing any trace the caller
+// propagated. Spans are named after their route once it is matched.
+func withTracing(h http.Handler) http.Handler {
+	return otelhttp.NewHandler(h, "api", otelhttp.WithSpanNameFormatter(
+		func(_ string, r *http.Request) string {
+			return r.Method + " " + unmatchedRoute
+		},
+	))
 }
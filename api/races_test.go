@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.neds.sh/matty/entain/api/proto/racing"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// recordingRacingServer records the ListRaces requests it is sent.
+type recordingRacingServer struct {
+	racing.UnimplementedRacingServer
+	requests []*racing.ListRacesRequest
+}
+
+func (s *recordingRacingServer) ListRaces(_ context.Context, in *racing.ListRacesRequest) (*racing.ListRacesResponse, error) {
+	s.requests = append(s.requests, in)
+	return &racing.ListRacesResponse{}, nil
+}
+
+func TestListRacesBinding(t *testing.T) {
+	visible := true
+
+	for _, tc := range []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		want     *racing.ListRacesRequest
+		wantCode int
+	}{
+		{
+			name:   "no parameters",
+			method: http.MethodGet,
+			target: "/v1/races",
+			want:   &racing.ListRacesRequest{},
+		},
+		{
+			name:   "query parameters",
+			method: http.MethodGet,
+			target: "/v1/races?filter.meeting_ids=1&filter.meeting_ids=5&filter.race_types=HARNESS&filter.venues=Menangle" +
+				"&filter.visible=true&filter.status=OPEN&order_by=name%20desc&page_size=10&page_token=abc&view=RACE_VIEW_FULL",
+			want: &racing.ListRacesRequest{
+				Filter: &racing.ListRacesRequestFilter{
+					MeetingIds: []int64{1, 5},
+					RaceTypes:  []racing.Meeting_RaceType{racing.Meeting_HARNESS},
+					Venues:     []string{"Menangle"},
+					Visible:    &visible,
+					Status:     racing.Race_OPEN,
+				},
+				OrderBy:   "name desc",
+				PageSize:  10,
+				PageToken: "abc",
+				View:      racing.RaceView_RACE_VIEW_FULL,
+			},
+		},
+		{
+			name:   "body of the compatible route",
+			method: http.MethodPost,
+			target: "/v1/list-races",
+			body:   `{"filter":{"meeting_ids":["1","5"]},"order_by":"name desc","page_token":"abc"}`,
+			want: &racing.ListRacesRequest{
+				Filter:    &racing.ListRacesRequestFilter{MeetingIds: []int64{1, 5}},
+				OrderBy:   "name desc",
+				PageToken: "abc",
+			},
+		},
+		{
+			name:     "invalid meeting id",
+			method:   http.MethodGet,
+			target:   "/v1/races?filter.meeting_ids=one",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "unknown race type",
+			method:   http.MethodGet,
+			target:   "/v1/races?filter.race_types=CAMEL",
+			wantCode: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := &recordingRacingServer{}
+
+			mux := runtime.NewServeMux()
+			require.NoError(t, racing.RegisterRacingHandlerServer(context.Background(), mux, server))
+
+			r := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			r.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			if tc.wantCode != 0 {
+				assert.Equal(t, tc.wantCode, w.Code)
+				assert.Empty(t, server.requests)
+
+				return
+			}
+
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			require.Len(t, server.requests, 1)
+			assert.True(t, proto.Equal(tc.want, server.requests[0]), "got %v, want %v", server.requests[0], tc.want)
+		})
+	}
+}
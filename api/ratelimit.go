@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.neds.sh/matty/entain/api/ratelimit"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultRoute names the bucket shared by the routes without their own limit.
+const defaultRoute = "*"
+
+// RateLimitConfig configures the rate each client may make requests at. Each
+// client is identified by its principal or, if anonymous, its address.
+// Credentials are limited by address, as they are checked before they are
+// verified.
+type RateLimitConfig struct {
+	Default           string            `config:"default" usage:"limit of requests each client may make to routes without their own, as <requests>/<period>, e.g. 100/1m, or empty for none"`
+	Routes            map[string]string `config:"routes" usage:"limits of requests to routes by pattern, e.g. /v1/races=10/1s,/v1/races/{id}=50/1s"`
+	Credentials       string            `config:"credentials" usage:"limit of requests presenting credentials each address may make, checked before the credentials are verified, e.g. 600/1m, or empty for none"`
+	TrustForwardedFor bool              `config:"trust-forwarded-for" usage:"take the address of clients from the address the proxy in front of the gateway appends to X-Forwarded-For"`
+}
+
+func (c *RateLimitConfig) Validate() error {
+	if c.Default != "" {
+		if _, err := ratelimit.ParseLimit(c.Default); err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+	}
+
+	if c.Credentials != "" {
+		if _, err := ratelimit.ParseLimit(c.Credentials); err != nil {
+			return fmt.Errorf("credentials: %w", err)
+		}
+	}
+
+	for route, limit := range c.Routes {
+		if !strings.HasPrefix(route, "/") {
+			return fmt.Errorf("routes: %q is not a route pattern", route)
+		}
+
+		if _, err := ratelimit.ParseLimit(limit); err != nil {
+			return fmt.Errorf("routes: %s: %w", route, err)
+		}
+	}
+
+	return nil
+}
+
+// routeLimit is the limit of requests to a route.
+type routeLimit struct {
+	routePattern
+	limit ratelimit.Limit
+}
+
+// rateLimiter limits the rate of each client's requests with token buckets,
+// which refill at the limit of their route.
+type rateLimiter struct {
+	store             ratelimit.Store
+	defaultLimit      *ratelimit.Limit
+	routes            []*routeLimit
+	credentialsLimit  *ratelimit.Limit
+	trustForwardedFor bool
+}
+
+// credentialsBucket names the bucket of each address's requests presenting
+// credentials.
+const credentialsBucket = "credentials"
+
+// newRateLimiter returns a rateLimiter keeping its buckets in store, or nil if
+// cfg sets no limits.
+func newRateLimiter(cfg RateLimitConfig, store ratelimit.Store) (*rateLimiter, error) {
+	if cfg.Default == "" && len(cfg.Routes) == 0 && cfg.Credentials == "" {
+		return nil, nil
+	}
+
+	l := &rateLimiter{store: store, trustForwardedFor: cfg.TrustForwardedFor}
+
+	if cfg.Default != "" {
+		limit, err := ratelimit.ParseLimit(cfg.Default)
+		if err != nil {
+			return nil, err
+		}
+
+		l.defaultLimit = &limit
+	}
+
+	if cfg.Credentials != "" {
+		limit, err := ratelimit.ParseLimit(cfg.Credentials)
+		if err != nil {
+			return nil, err
+		}
+
+		l.credentialsLimit = &limit
+	}
+
+	for pattern, s := range cfg.Routes {
+		limit, err := ratelimit.ParseLimit(s)
+		if err != nil {
+			return nil, err
+		}
+
+		l.routes = append(l.routes, &routeLimit{routePattern: newRoutePattern(pattern), limit: limit})
+	}
+
+	// Where patterns overlap, the most specific is applied.
+	sort.Slice(l.routes, func(i, j int) bool {
+		if a, b := l.routes[i].literals(), l.routes[j].literals(); a != b {
+			return a > b
+		}
+
+		return l.routes[i].pattern < l.routes[j].pattern
+	})
+
+	return l, nil
+}
+
+// limit refuses requests beyond the limit of their route with a 429. Every
+// limited response reports the state of its bucket in RateLimit-* headers.
+// Health checks are never limited, so that probes are not refused.
+func (l *rateLimiter) limit(mux *runtime.ServeMux, h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isHealthCheck(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		route, limit, ok := l.limitOf(r.URL.Path)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// Refused requests never reach the mux, so are recorded under the
+		// route they were limited by, if known.
+		recorded := route
+		if route == defaultRoute {
+			recorded = ""
+		}
+
+		if l.take(mux, w, r, l.client(r)+" "+route, recorded, limit) {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// limitCredentials refuses requests presenting credentials beyond the
+// credentials limit of their address with a 429. It precedes authentication,
+// so that clients cannot guess credentials faster than the limit, whether or
+// not their guesses are right.
+func (l *rateLimiter) limitCredentials(mux *runtime.ServeMux, h http.Handler) http.Handler {
+	if l == nil || l.credentialsLimit == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isHealthCheck(r) || (r.Header.Get("Authorization") == "" && r.Header.Get(apiKeyHeader) == "") {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if l.take(mux, w, r, l.address(r)+" "+credentialsBucket, "", *l.credentialsLimit) {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// take takes a token for a request from the bucket of key, reporting its
+// state in RateLimit-* headers, and reports whether the request may be
+// served. Otherwise, it has been refused with a 429, recorded under route if
+// that is not empty.
+func (l *rateLimiter) take(
+	mux *runtime.ServeMux,
+	w http.ResponseWriter,
+	r *http.Request,
+	key string,
+	route string,
+	limit ratelimit.Limit,
+) bool {
+	result, err := l.store.Take(r.Context(), key, limit, time.Now())
+	if err != nil {
+		// The store is only an aid to availability, so requests are served
+		// if it fails.
+		log.WithField("request_id", requestID(r.Context())).WithError(err).Error("failed checking rate limit")
+		return true
+	}
+
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", seconds(result.Reset))
+	header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s", limit.Requests, seconds(limit.Period)))
+
+	if result.Allowed {
+		return true
+	}
+
+	header.Set("Retry-After", seconds(result.RetryAfter))
+
+	if route != "" {
+		setRoute(r.Context(), r.Method, route)
+	}
+
+	_, outbound := runtime.MarshalerForRequest(mux, r)
+	runtime.HTTPError(r.Context(), mux, outbound, w, r, status.Error(codes.ResourceExhausted, "rate limit exceeded"))
+
+	return false
+}
+
+func isHealthCheck(r *http.Request) bool {
+	return r.URL.Path == "/healthz" || r.URL.Path == "/readyz"
+}
+
+// limitOf returns the bucket name and limit of requests to path, which is that
+// of the most specific route matching it, or the default.
+func (l *rateLimiter) limitOf(path string) (string, ratelimit.Limit, bool) {
+	for _, route := range l.routes {
+		if _, ok := route.match(path); ok {
+			return route.pattern, route.limit, true
+		}
+	}
+
+	if l.defaultLimit != nil {
+		return defaultRoute, *l.defaultLimit, true
+	}
+
+	return "", ratelimit.Limit{}, false
+}
+
+// client identifies who made a request: its principal, which API keys also
+// authenticate, or else its address.
+func (l *rateLimiter) client(r *http.Request) string {
+	if p, _ := r.Context().Value(principalKey{}).(principal); p.Subject != "" {
+		return "subject:" + p.Subject
+	}
+
+	return l.address(r)
+}
+
+// address identifies the address a request was made from.
+func (l *rateLimiter) address(r *http.Request) string {
+	if l.trustForwardedFor {
+		// Clients may send X-Forwarded-For themselves, so only the address
+		// appended by our proxy is trusted.
+		forwarded := r.Header.Values("X-Forwarded-For")
+		if len(forwarded) > 0 {
+			addrs := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := net.ParseIP(strings.TrimSpace(addrs[len(addrs)-1])); ip != nil {
+				return "ip:" + ip.String()
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return "ip:" + host
+}
+
+// seconds renders d as a whole number of seconds, rounded up, as rate limit
+// headers are given.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,161 @@
+// Package ratelimit limits the rate at which clients make requests, with token
+// buckets held in a Store, which replicas of the gateway may share.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit is the capacity and refill rate of a token bucket: a client may make
+// up to Requests at once, which are replenished evenly over Period.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseLimit parses a limit given as <requests>/<period>, where the period is a
+// duration or, for one of them, a unit, e.g. 100/1m, 10/s or 5/30s.
+func ParseLimit(s string) (Limit, error) {
+	requests, period, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("limit %q must be <requests>/<period>", s)
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("limit %q must allow a positive number of requests", s)
+	}
+
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("limit %q must have a positive period", s)
+	}
+
+	return Limit{Requests: n, Period: d}, nil
+}
+
+func (l Limit) String() string {
+	return strconv.Itoa(l.Requests) + "/" + l.Period.String()
+}
+
+// perNanosecond returns the rate tokens are replenished at.
+func (l Limit) perNanosecond() float64 {
+	return float64(l.Requests) / float64(l.Period)
+}
+
+// Result is the state of a bucket after a request took from it.
+type Result struct {
+	// Allowed reports whether the bucket had a token for the request.
+	Allowed bool
+
+	// Remaining is the number of whole tokens left in the bucket.
+	Remaining int
+
+	// RetryAfter is how long until the next token is available, if the
+	// request was not allowed.
+	RetryAfter time.Duration
+
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Store holds the token buckets of clients. A store shared by replicas of the
+// gateway, e.g. in Redis, lets them enforce limits together, where each would
+// otherwise allow its own.
+type Store interface {
+	// Take takes a token for a request from the bucket of key, which is
+	// created full with the given limit.
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// ErrInvalidLimit is returned for limits which allow no requests.
+var ErrInvalidLimit = errors.New("limit must allow a positive number of requests per positive period")
+
+// sweepInterval is how often a MemoryStore drops the buckets of clients which
+// have been idle long enough for them to be full, as they are recreated so.
+const sweepInterval = time.Minute
+
+// MemoryStore is a Store held in memory, which suits a single replica.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	limit   Limit
+	tokens  float64
+	updated time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	if limit.Requests <= 0 || limit.Period <= 0 {
+		return Result{}, ErrInvalidLimit
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok || b.limit != limit {
+		b = &bucket{limit: limit, tokens: float64(limit.Requests), updated: now}
+		s.buckets[key] = b
+	}
+
+	b.refill(now)
+
+	rate := limit.perNanosecond()
+	result := Result{Allowed: b.tokens >= 1}
+
+	if result.Allowed {
+		b.tokens--
+	} else {
+		result.RetryAfter = time.Duration(math.Ceil((1 - b.tokens) / rate))
+	}
+
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration(math.Ceil((float64(limit.Requests) - b.tokens) / rate))
+
+	return result, nil
+}
+
+// refill adds the tokens replenished since the bucket was last updated.
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Requests), b.tokens+float64(elapsed)*b.limit.perNanosecond())
+		b.updated = now
+	}
+}
+
+// sweep drops the buckets which are full by now.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		b.refill(now)
+
+		if b.tokens >= float64(b.limit.Requests) {
+			delete(s.buckets, key)
+		}
+	}
+
+	s.lastSweep = now
+}
@@ -0,0 +1,183 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimit(t *testing.T) {
+	for _, tc := range []struct {
+		s       string
+		want    Limit
+		wantErr string
+	}{
+		{s: "10/s", want: Limit{Requests: 10, Period: time.Second}},
+		{s: "5/30s", want: Limit{Requests: 5, Period: 30 * time.Second}},
+		{s: "100/1m", want: Limit{Requests: 100, Period: time.Minute}},
+		{s: "3/ms", want: Limit{Requests: 3, Period: time.Millisecond}},
+		{s: "1/1h30m", want: Limit{Requests: 1, Period: 90 * time.Minute}},
+		{s: " 10/s ", want: Limit{Requests: 10, Period: time.Second}},
+		{s: "10", wantErr: "must be <requests>/<period>"},
+		{s: "", wantErr: "must be <requests>/<period>"},
+		{s: "0/s", wantErr: "positive number of requests"},
+		{s: "-1/s", wantErr: "positive number of requests"},
+		{s: "ten/s", wantErr: "positive number of requests"},
+		{s: "10/", wantErr: "positive period"},
+		{s: "10/0s", wantErr: "positive period"},
+		{s: "10/-1s", wantErr: "positive period"},
+		{s: "10/fortnight", wantErr: "positive period"},
+	} {
+		t.Run(tc.s, func(t *testing.T) {
+			limit, err := ParseLimit(tc.s)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, limit)
+		})
+	}
+}
+
+func TestLimitString(t *testing.T) {
+	assert.Equal(t, "5/30s", Limit{Requests: 5, Period: 30 * time.Second}.String())
+}
+
+func TestMemoryStoreTake(t *testing.T) {
+	start := time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)
+	limit := Limit{Requests: 2, Period: time.Second}
+
+	s := NewMemoryStore()
+
+	// Each step takes from the bucket after the given time since the start.
+	for _, step := range []struct {
+		after time.Duration
+		want  Result
+	}{
+		// The bucket is created full.
+		{after: 0, want: Result{Allowed: true, Remaining: 1, Reset: 500 * time.Millisecond}},
+		{after: 0, want: Result{Allowed: true, Remaining: 0, Reset: time.Second}},
+		{after: 0, want: Result{Allowed: false, Remaining: 0, RetryAfter: 500 * time.Millisecond, Reset: time.Second}},
+
+		// Tokens are replenished evenly, a fraction at a time.
+		{after: 250 * time.Millisecond, want: Result{Allowed: false, Remaining: 0, RetryAfter: 250 * time.Millisecond, Reset: 750 * time.Millisecond}},
+		{after: 500 * time.Millisecond, want: Result{Allowed: true, Remaining: 0, Reset: time.Second}},
+
+		// The bucket holds no more than the limit.
+		{after: time.Hour, want: Result{Allowed: true, Remaining: 1, Reset: 500 * time.Millisecond}},
+	} {
+		result, err := s.Take(context.Background(), "client", limit, start.Add(step.after))
+		require.NoError(t, err)
+		assert.Equal(t, step.want, result, "after %s", step.after)
+	}
+}
+
+func TestMemoryStoreTakeRounding(t *testing.T) {
+	now := time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)
+	limit := Limit{Requests: 3, Period: time.Second}
+
+	s := NewMemoryStore()
+
+	for i := 0; i < limit.Requests; i++ {
+		_, err := s.Take(context.Background(), "client", limit, now)
+		require.NoError(t, err)
+	}
+
+	// A token takes a third of a second, which is rounded up, so that clients
+	// retrying after it are not refused again.
+	result, err := s.Take(context.Background(), "client", limit, now)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 333333334*time.Nanosecond, result.RetryAfter)
+	assert.Equal(t, time.Second, result.Reset)
+
+	result, err = s.Take(context.Background(), "client", limit, now.Add(result.RetryAfter))
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestMemoryStoreTakeKeys(t *testing.T) {
+	now := time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)
+	limit := Limit{Requests: 1, Period: time.Minute}
+
+	s := NewMemoryStore()
+
+	result, err := s.Take(context.Background(), "alice", limit, now)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	result, err = s.Take(context.Background(), "alice", limit, now)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	// Each key has its own bucket.
+	result, err = s.Take(context.Background(), "bob", limit, now)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestMemoryStoreTakeLimitChanged(t *testing.T) {
+	now := time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	s := NewMemoryStore()
+
+	result, err := s.Take(context.Background(), "client", Limit{Requests: 1, Period: time.Minute}, now)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	// A bucket taken from with another limit, e.g. after the gateway is
+	// reconfigured, is replaced by a full one with the new limit.
+	result, err = s.Take(context.Background(), "client", Limit{Requests: 5, Period: time.Minute}, now)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Allowed: true, Remaining: 4, Reset: 12 * time.Second}, result)
+}
+
+func TestMemoryStoreTakeInvalidLimit(t *testing.T) {
+	s := NewMemoryStore()
+
+	for _, limit := range []Limit{{}, {Requests: 1}, {Period: time.Second}, {Requests: -1, Period: time.Second}} {
+		_, err := s.Take(context.Background(), "client", limit, time.Now())
+		assert.ErrorIs(t, err, ErrInvalidLimit, "%+v", limit)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	start := time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)
+	fast := Limit{Requests: 2, Period: time.Second}
+	slow := Limit{Requests: 2, Period: time.Hour}
+
+	s := NewMemoryStore()
+
+	take := func(key string, limit Limit, now time.Time) {
+		t.Helper()
+
+		_, err := s.Take(context.Background(), key, limit, now)
+		require.NoError(t, err)
+	}
+
+	// The first take sweeps the empty store, so the next sweep is due a
+	// sweep interval later.
+	take("idle", fast, start)
+	take("slow", slow, start)
+
+	take("busy", fast, start.Add(sweepInterval-time.Nanosecond))
+	assert.Len(t, s.buckets, 3, "swept early")
+
+	// The idle bucket has refilled by the next sweep, so is dropped, unlike
+	// the slow one.
+	take("busy", fast, start.Add(sweepInterval))
+	assert.Len(t, s.buckets, 2)
+	assert.Contains(t, s.buckets, "busy")
+	assert.Contains(t, s.buckets, "slow")
+	assert.Equal(t, start.Add(sweepInterval), s.lastSweep)
+
+	// A dropped bucket is recreated full.
+	result, err := s.Take(context.Background(), "idle", fast, start.Add(sweepInterval))
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Remaining)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/api/ratelimit"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// okHandler serves every request it is passed with a 200.
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+// serve serves a GET of path from addr with the headers, returning the
+// response.
+func serve(h http.Handler, path string, addr string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.RemoteAddr = addr
+
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestRateLimitConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		cfg     RateLimitConfig
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "valid", cfg: RateLimitConfig{Default: "100/1m", Routes: map[string]string{"/v1/races": "10/s"}, Credentials: "600/1m"}},
+		{name: "invalid default", cfg: RateLimitConfig{Default: "100"}, wantErr: "default:"},
+		{name: "invalid route", cfg: RateLimitConfig{Routes: map[string]string{"v1/races": "10/s"}}, wantErr: "is not a route pattern"},
+		{name: "invalid route limit", cfg: RateLimitConfig{Routes: map[string]string{"/v1/races": "0/s"}}, wantErr: "routes: /v1/races:"},
+		{name: "invalid credentials", cfg: RateLimitConfig{Credentials: "10/0s"}, wantErr: "credentials:"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRateLimiterLimit(t *testing.T) {
+	mux := runtime.NewServeMux()
+
+	l, err := newRateLimiter(RateLimitConfig{
+		Default: "3/1m",
+		Routes: map[string]string{
+			"/v1/races/{id}": "2/1m",
+			"/v1/races/1":    "1/1m",
+		},
+	}, ratelimit.NewMemoryStore())
+	require.NoError(t, err)
+
+	h := l.limit(mux, okHandler)
+
+	// The most specific route applies.
+	w := serve(h, "/v1/races/1", "10.0.0.1:1234", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", w.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "1;w=60", w.Header().Get("RateLimit-Policy"))
+
+	w = serve(h, "/v1/races/1", "10.0.0.1:1234", nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	// Each route has its own bucket, as does each client.
+	assert.Equal(t, http.StatusOK, serve(h, "/v1/races/2", "10.0.0.1:1234", nil).Code)
+	assert.Equal(t, http.StatusOK, serve(h, "/v1/races/1", "10.0.0.2:1234", nil).Code)
+
+	// Routes without their own limit share the default.
+	for _, path := range []string{"/v1/meetings", "/v1/sports/events", "/v1/meetings"} {
+		assert.Equal(t, http.StatusOK, serve(h, path, "10.0.0.1:1234", nil).Code, path)
+	}
+
+	assert.Equal(t, http.StatusTooManyRequests, serve(h, "/v1/sports/events", "10.0.0.1:1234", nil).Code)
+
+	// Health checks are never limited.
+	for i := 0; i < 5; i++ {
+		w := serve(h, "/healthz", "10.0.0.1:1234", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+	}
+}
+
+func TestRateLimiterLimitRecordsRoute(t *testing.T) {
+	l, err := newRateLimiter(RateLimitConfig{Routes: map[string]string{"/v1/races/{id}": "1/1m"}}, ratelimit.NewMemoryStore())
+	require.NoError(t, err)
+
+	h := l.limit(runtime.NewServeMux(), okHandler)
+
+	var route string
+
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/v1/races/7", nil)
+		r = r.WithContext(withRouteRecorder(r.Context(), &route))
+
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	assert.Equal(t, "/v1/races/{id}", route)
+}
+
+func TestRateLimiterClient(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		principal         principal
+		remoteAddr        string
+		forwardedFor      []string
+		trustForwardedFor bool
+		want              string
+	}{
+		{name: "principal", principal: principal{Subject: "alice"}, remoteAddr: "10.0.0.1:1234", want: "subject:alice"},
+		{name: "address", remoteAddr: "10.0.0.1:1234", want: "ip:10.0.0.1"},
+		{name: "address without port", remoteAddr: "10.0.0.1", want: "ip:10.0.0.1"},
+		{name: "untrusted forwarded for", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"192.0.2.1"}, want: "ip:10.0.0.1"},
+		{
+			name:              "forwarded for",
+			remoteAddr:        "10.0.0.1:1234",
+			forwardedFor:      []string{"192.0.2.1, 192.0.2.2"},
+			trustForwardedFor: true,
+			want:              "ip:192.0.2.2",
+		},
+		{
+			name:              "last forwarded for header",
+			remoteAddr:        "10.0.0.1:1234",
+			forwardedFor:      []string{"192.0.2.1", "192.0.2.3"},
+			trustForwardedFor: true,
+			want:              "ip:192.0.2.3",
+		},
+		{
+			name:              "invalid forwarded for",
+			remoteAddr:        "10.0.0.1:1234",
+			forwardedFor:      []string{"unknown"},
+			trustForwardedFor: true,
+			want:              "ip:10.0.0.1",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &rateLimiter{trustForwardedFor: tc.trustForwardedFor}
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/races", nil)
+			r.RemoteAddr = tc.remoteAddr
+
+			for _, value := range tc.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			if tc.principal.Subject != "" {
+				r = r.WithContext(context.WithValue(r.Context(), principalKey{}, tc.principal))
+			}
+
+			assert.Equal(t, tc.want, l.client(r))
+		})
+	}
+}
+
+func TestRateLimiterLimitCredentials(t *testing.T) {
+	keys := newTestKeys(t)
+	authn := newTestAuthenticator(t, keys, defaultAuthConfig())
+	mux := runtime.NewServeMux()
+
+	l, err := newRateLimiter(RateLimitConfig{Credentials: "2/1m"}, ratelimit.NewMemoryStore())
+	require.NoError(t, err)
+
+	// As the gateway serves requests, credentials are limited before they are
+	// authenticated.
+	h := l.limitCredentials(mux, authn.authenticate(mux, l.limit(mux, okHandler)))
+
+	guess := map[string]string{apiKeyHeader: "guess"}
+	key := map[string]string{apiKeyHeader: "key"}
+
+	// Failed attempts are limited, with the remaining attempts reported.
+	w := serve(h, "/v1/races", "10.0.0.1:1234", guess)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+
+	assert.Equal(t, http.StatusUnauthorized, serve(h, "/v1/races", "10.0.0.1:1234", guess).Code)
+
+	w = serve(h, "/v1/races", "10.0.0.1:1234", map[string]string{"Authorization": "Bearer guess"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+
+	// Once they are, even valid credentials are refused from that address,
+	// as they are not verified.
+	assert.Equal(t, http.StatusTooManyRequests, serve(h, "/v1/races", "10.0.0.1:1234", key).Code)
+
+	// Other addresses have their own bucket.
+	assert.Equal(t, http.StatusOK, serve(h, "/v1/races", "10.0.0.2:1234", key).Code)
+
+	// Requests without credentials are not limited by it, nor are health
+	// checks, which are only refused for their credentials.
+	assert.Equal(t, http.StatusOK, serve(h, "/v1/races", "10.0.0.1:1234", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(h, "/healthz", "10.0.0.1:1234", guess).Code)
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	l, err := newRateLimiter(RateLimitConfig{}, ratelimit.NewMemoryStore())
+	require.NoError(t, err)
+	assert.Nil(t, l)
+
+	// A nil limiter limits nothing.
+	h := l.limitCredentials(nil, l.limit(nil, okHandler))
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, serve(h, "/v1/races", "10.0.0.1:1234", map[string]string{apiKeyHeader: "guess"}).Code)
+	}
+
+	// Credentials alone enable the limiter, without limiting routes.
+	l, err = newRateLimiter(RateLimitConfig{Credentials: "1/1m"}, ratelimit.NewMemoryStore())
+	require.NoError(t, err)
+	require.NotNil(t, l)
+
+	h = l.limit(nil, okHandler)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, serve(h, "/v1/races", "10.0.0.1:1234", nil).Code)
+	}
+}
+
+func TestSeconds(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		0:                       "0",
+		time.Nanosecond:         "1",
+		time.Second:             "1",
+		1500 * time.Millisecond: "2",
+		time.Minute:             "60",
+	} {
+		assert.Equal(t, want, seconds(d), "%s", d)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// requestIDHeader is the header a request's ID is accepted from and
+	// returned in.
+	requestIDHeader = "X-Request-Id"
+
+	// requestIDKey is the gRPC metadata key a request's ID is forwarded to the
+	// backends in.
+	requestIDKey = "x-request-id"
+
+	// maxRequestIDLength bounds the length of accepted request IDs.
+	maxRequestIDLength = 128
+)
+
+// requestIDContextKey is the context key of a request's ID.
+type requestIDContextKey struct{}
+
+// withRequestID assigns each request an ID, which is returned in the response,
+// forwarded to the backends and logged, to correlate their logs. The caller's
+// X-Request-Id is used if valid, e.g. so that a load balancer's IDs are kept.
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, id)))
+	})
+}
+
+// requestID returns the ID assigned to a request, or an empty string.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// forwardRequestID is a runtime.ServeMux metadata annotator forwarding the ID
+// of each request to the backends.
+func forwardRequestID(ctx context.Context, _ *http.Request) metadata.MD {
+	if id := requestID(ctx); id != "" {
+		return metadata.Pairs(requestIDKey, id)
+	}
+
+	return nil
+}
+
+// validRequestID reports whether an ID is safe to log and forward: printable
+// ASCII of a bounded length.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+
+	// crypto/rand only fails if the OS cannot supply randomness, in which
+	// case an ID is still better than none.
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// logRequest logs a request once it has been served, along with the subject
+// it was made by, if any. Health checks are only logged when debugging, as
+// they are frequent.
+func logRequest(r *http.Request, route, subject string, status int, duration time.Duration) {
+	fields := log.Fields{
+		"request_id": requestID(r.Context()),
+		"method":     r.Method,
+		"route":      route,
+		"path":       r.URL.Path,
+		"status":     status,
+		"duration":   duration.String(),
+	}
+
+	if subject != "" {
+		fields["subject"] = subject
+	}
+
+	if span := trace.SpanContextFromContext(r.Context()); span.HasTraceID() {
+		fields["trace_id"] = span.TraceID().String()
+	}
+
+	entry := log.WithFields(fields)
+
+	switch {
+	case status >= http.StatusInternalServerError:
+		entry.Error("request failed")
+	case route == "/healthz" || route == "/readyz":
+		entry.Debug("request served")
+	default:
+		entry.Info("request served")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+// unmatchedRoute labels requests which matched no route, so that arbitrary
+// paths do not each create a series.
+const unmatchedRoute = "unmatched"
+
+// routeKey is the context key of the *string a request's route is recorded in
+// once the mux has matched it.
+type routeKey struct{}
+
+// withRouteRecorder returns a context recording the route a request matches,
+// e.g. /v1/races/{id}, in route.
+func withRouteRecorder(ctx context.Context, route *string) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// setRoute records the route a request matched, and names its span after it.
+func setRoute(ctx context.Context, method, pattern string) {
+	if route, ok := ctx.Value(routeKey{}).(*string); ok {
+		*route = pattern
+	}
+
+	span := trace.SpanFromContext(ctx)
+	span.SetName(method + " " + pattern)
+	span.SetAttributes(semconv.HTTPRouteKey.String(pattern))
+}
+
+// recordRoute is a runtime.ServeMux metadata annotator, which the mux calls
+// for every gateway request once it has matched its route. It records the
+// route but adds no metadata.
+func recordRoute(ctx context.Context, r *http.Request) metadata.MD {
+	if pattern, ok := runtime.HTTPPathPattern(ctx); ok {
+		setRoute(ctx, r.Method, pattern)
+	}
+
+	return nil
+}
+
+// withRoute records the route of requests to handlers added by HandlePath,
+// which are not annotated by the mux.
+func withRoute(pattern string, h runtime.HandlerFunc) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		setRoute(r.Context(), r.Method, pattern)
+		h(w, r, params)
+	}
+}
+
+// routePattern is a route pattern, e.g. /v1/races/{id}, in which variables
+// match any one segment of a path.
+type routePattern struct {
+	pattern  string
+	segments []string
+}
+
+func newRoutePattern(pattern string) routePattern {
+	return routePattern{pattern: pattern, segments: pathSegments(pattern)}
+}
+
+// match returns the values of the pattern's variables in path, if it matches.
+func (p routePattern) match(path string) (map[string]string, bool) {
+	segments := pathSegments(path)
+	if len(segments) != len(p.segments) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+
+	for i, segment := range p.segments {
+		switch {
+		case isVariable(segment):
+			params[strings.Trim(segment, "{}")] = segments[i]
+		case segment != segments[i]:
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+// literals counts the segments of the pattern which are not variables, which
+// is greater the more specific the pattern is.
+func (p routePattern) literals() int {
+	n := 0
+
+	for _, segment := range p.segments {
+		if !isVariable(segment) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func pathSegments(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+func isVariable(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
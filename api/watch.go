@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"git.neds.sh/matty/entain/api/proto/racing"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/utilities"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// watchRacesPath is the path WatchRaces is served on.
+	watchRacesPath = "/v1/watch-races"
+
+	// watchHeartbeatInterval is how often an idle event stream is sent a
+	// comment, so that proxies do not time the connection out.
+	watchHeartbeatInterval = 15 * time.Second
+)
+
+// watchRacesHandler serves the WatchRaces stream as Server-Sent Events. Each
+// message is sent as an event named after its type (snapshot, added, updated
+// or removed) with the message as JSON data. The filter is read from query
+// parameters, e.g. ?filter.meeting_ids=1&filter.status=OPEN.
+func watchRacesHandler(mux *runtime.ServeMux, client racing.RacingClient) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		_, outbound := runtime.MarshalerForRequest(mux, r)
+
+		ctx, err := runtime.AnnotateContext(r.Context(), mux, r, "/racing.Racing/WatchRaces", runtime.WithHTTPPathPattern(watchRacesPath))
+		if err != nil {
+			runtime.HTTPError(r.Context(), mux, outbound, w, r, err)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			runtime.HTTPError(ctx, mux, outbound, w, r, status.Error(codes.Internal, "streaming unsupported"))
+			return
+		}
+
+		var req racing.WatchRacesRequest
+		if err := runtime.PopulateQueryParameters(&req, r.URL.Query(), utilities.NewDoubleArray(nil)); err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, status.Error(codes.InvalidArgument, err.Error()))
+			return
+		}
+
+		stream, err := client.WatchRaces(ctx, &req)
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+
+		// Wait for the snapshot before committing to a stream, so that errors
+		// such as an invalid filter are still reported with an HTTP status.
+		snapshot, err := stream.Recv()
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		if err := writeEvent(w, outbound, strings.ToLower(snapshot.Type.String()), snapshot); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		msgs := make(chan *racing.WatchRacesResponse)
+		errs := make(chan error, 1)
+
+		go func() {
+			for {
+				msg, err := stream.Recv()
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				select {
+				case msgs <- msg:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		heartbeat := time.NewTicker(watchHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-heartbeat.C:
+				if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			case msg := <-msgs:
+				if err := writeEvent(w, outbound, strings.ToLower(msg.Type.String()), msg); err != nil {
+					return
+				}
+			case err := <-errs:
+				if !errors.Is(err, io.EOF) {
+					_ = writeEvent(w, outbound, "error", status.Convert(err).Proto())
+					flusher.Flush()
+				}
+				return
+			}
+
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes msg as a single Server-Sent Event.
+func writeEvent(w io.Writer, marshaler runtime.Marshaler, event string, msg proto.Message) error {
+	data, err := marshaler.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+
+	return err
+}
@@ -0,0 +1,396 @@
+// Package config loads the configuration of our binaries from defaults, an
+// optional YAML or TOML file, environment variables and command line flags,
+// and sets up the logging, tracing and metrics they configure.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Validator is implemented by configurations which check their own values
+// once loaded.
+type Validator interface {
+	Validate() error
+}
+
+// Load populates cfg, a pointer to a struct whose fields are tagged with their
+// names and descriptions, e.g.
+//
+//	GRPCEndpoint string `config:"grpc-endpoint" usage:"gRPC server endpoint"`
+//
+// Each field is taken from the first of these to set it:
+//   - a command line flag, e.g. -grpc-endpoint;
+//   - an environment variable, e.g. RACING_GRPC_ENDPOINT for the binary name
+//     "racing";
+//   - the YAML (.yaml or .yml) or TOML (.toml) file named by -config or, e.g.,
+//     RACING_CONFIG;
+//   - the value cfg already holds, which is its default.
+//
+// Tagged struct fields group their fields, so that a field "cert-file" in a
+// group "tls" is set by -tls.cert-file, RACING_TLS_CERT_FILE or a tls table
+// in the file. Fields may be strings, bools, ints, floats, durations, lists of
+// strings, which are separated by commas, or maps of strings to any of these
+// but lists, which are given as "key=value" pairs separated by commas.
+//
+// Once loaded, cfg is validated if it implements Validator. Load returns the
+// arguments remaining after the flags.
+func Load(name string, args []string, cfg interface{}) ([]string, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config must be a pointer to a struct, not %T", cfg)
+	}
+
+	fields, err := collectFields(v.Elem(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.ToUpper(name) + "_"
+
+	// Flags are parsed first, to find the file, but are applied last so that
+	// they take precedence over it.
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	file := flags.String("config", os.Getenv(prefix+"CONFIG"), "path to a YAML or TOML configuration file")
+
+	flagValues := make(map[string]string)
+
+	for _, f := range fields {
+		flags.Var(&flagValue{field: f, def: format(f.value), values: flagValues}, f.name, f.usage)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *file != "" {
+		if err := loadFile(*file, fields); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", *file, err)
+		}
+	}
+
+	for _, f := range fields {
+		env := envName(prefix, f.name)
+
+		if s, ok := os.LookupEnv(env); ok {
+			if err := set(f.value, s); err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", env, s, err)
+			}
+		}
+	}
+
+	for _, f := range fields {
+		if s, ok := flagValues[f.name]; ok {
+			if err := set(f.value, s); err != nil {
+				return nil, fmt.Errorf("invalid -%s %q: %w", f.name, s, err)
+			}
+		}
+	}
+
+	if validator, ok := cfg.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+	}
+
+	return flags.Args(), nil
+}
+
+// field is a single configurable value, named by its dotted path.
+type field struct {
+	name  string
+	usage string
+	value reflect.Value
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// collectFields returns the tagged fields of v, descending into groups.
+func collectFields(v reflect.Value, prefix string) ([]field, error) {
+	var fields []field
+
+	for i := 0; i < v.NumField(); i++ {
+		tag, ok := v.Type().Field(i).Tag.Lookup("config")
+		if !ok {
+			continue
+		}
+
+		name := prefix + tag
+		value := v.Field(i)
+
+		if value.Kind() == reflect.Struct {
+			group, err := collectFields(value, name+".")
+			if err != nil {
+				return nil, err
+			}
+
+			fields = append(fields, group...)
+			continue
+		}
+
+		if !settable(value.Type()) {
+			return nil, fmt.Errorf("config field %s has unsupported type %s", name, value.Type())
+		}
+
+		fields = append(fields, field{
+			name:  name,
+			usage: v.Type().Field(i).Tag.Get("usage"),
+			value: value,
+		})
+	}
+
+	return fields, nil
+}
+
+func settable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int32, reflect.Int64, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String &&
+			t.Elem().Kind() != reflect.Slice && t.Elem().Kind() != reflect.Map &&
+			settable(t.Elem())
+	}
+
+	return false
+}
+
+// set parses s into v, which must be of a settable type.
+func set(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+
+		v.SetBool(b)
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+
+		v.SetFloat(f)
+	case v.Kind() == reflect.Slice:
+		var values []string
+
+		for _, value := range strings.Split(s, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+
+		v.Set(reflect.ValueOf(values))
+	case v.Kind() == reflect.Map:
+		m := reflect.MakeMap(v.Type())
+
+		for _, pair := range strings.Split(s, ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("%q must be <key>=<value>", pair)
+			}
+
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := set(elem, strings.TrimSpace(value)); err != nil {
+				return err
+			}
+
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(key)), elem)
+		}
+
+		v.Set(m)
+	default:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+
+		v.SetInt(n)
+	}
+
+	return nil
+}
+
+// format renders v as set would parse it.
+func format(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Slice:
+		return strings.Join(v.Interface().([]string), ",")
+	case reflect.Map:
+		break
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+
+	pairs := make([]string, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		pairs = append(pairs, key.String()+"="+fmt.Sprint(v.MapIndex(key).Interface()))
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+// envName returns the environment variable naming a field, e.g.
+// RACING_TLS_CERT_FILE for "tls.cert-file".
+func envName(prefix, name string) string {
+	return prefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+}
+
+// flagValue records a flag's value, once validated, to be applied after the
+// file and environment.
+type flagValue struct {
+	field  field
+	def    string
+	values map[string]string
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+
+	return f.def
+}
+
+func (f *flagValue) Set(s string) error {
+	if err := set(reflect.New(f.field.value.Type()).Elem(), s); err != nil {
+		return err
+	}
+
+	f.values[f.field.name] = s
+
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.field.value.Kind() == reflect.Bool
+}
+
+// loadFile applies the values in a YAML or TOML file to the fields.
+func loadFile(path string, fields []field) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]interface{})
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &values)
+	case ".toml":
+		err = toml.Unmarshal(b, &values)
+	default:
+		return fmt.Errorf("unknown format %q, expected .yaml, .yml or .toml", ext)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]field, len(fields))
+	for _, f := range fields {
+		byName[f.name] = f
+	}
+
+	return applyFile(values, "", byName)
+}
+
+// applyFile applies file values to the fields, descending into groups. Keys
+// naming no field are rejected, to catch typos.
+func applyFile(values map[string]interface{}, prefix string, fields map[string]field) error {
+	for key, value := range values {
+		name := prefix + key
+
+		if f, ok := fields[name]; ok {
+			if err := set(f.value, fileString(value)); err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+
+			continue
+		}
+
+		group, ok := value.(map[string]interface{})
+		if !ok || !hasGroup(fields, name) {
+			return fmt.Errorf("unknown key %s", name)
+		}
+
+		if err := applyFile(group, name+".", fields); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func hasGroup(fields map[string]field, name string) bool {
+	for f := range fields {
+		if strings.HasPrefix(f, name+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fileString renders a decoded file value as set parses it. Lists are joined
+// by commas, and tables are rendered as "key=value" pairs.
+func fileString(value interface{}) string {
+	if list, ok := value.([]interface{}); ok {
+		values := make([]string, len(list))
+		for i, v := range list {
+			values[i] = fmt.Sprint(v)
+		}
+
+		return strings.Join(values, ",")
+	}
+
+	table, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Sprint(value)
+	}
+
+	pairs := make([]string, 0, len(table))
+	for key, v := range table {
+		pairs = append(pairs, key+"="+fmt.Sprint(v))
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+// IsHelp reports whether err is the result of -h or -help, after which the
+// binary should exit without reporting an error.
+func IsHelp(err error) bool {
+	return errors.Is(err, flag.ErrHelp)
+}
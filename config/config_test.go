@@ -0,0 +1,298 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testTLS struct {
+	Enabled  bool   `config:"enabled" usage:"enable TLS"`
+	CertFile string `config:"cert-file" usage:"PEM certificate"`
+}
+
+type testConfig struct {
+	Endpoint string                   `config:"endpoint" usage:"endpoint"`
+	Seed     bool                     `config:"seed" usage:"seed"`
+	Timeout  time.Duration            `config:"timeout" usage:"timeout"`
+	Retries  int                      `config:"retries" usage:"retries"`
+	Ratio    float64                  `config:"ratio" usage:"ratio"`
+	Roles    []string                 `config:"roles" usage:"roles"`
+	Timeouts map[string]time.Duration `config:"timeouts" usage:"timeouts"`
+	Limits   map[string]int           `config:"limits" usage:"limits"`
+	TLS      testTLS                  `config:"tls"`
+
+	// Untagged fields are left alone.
+	Untagged string
+}
+
+func (c *testConfig) Validate() error {
+	if c.Retries < 0 {
+		return errors.New("retries must not be negative")
+	}
+
+	return nil
+}
+
+func defaultTestConfig() testConfig {
+	return testConfig{
+		Endpoint: "localhost:9000",
+		Timeout:  time.Second,
+		Retries:  3,
+		Roles:    []string{"trader"},
+		Untagged: "untouched",
+	}
+}
+
+func TestLoad(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		files    map[string]string
+		env      map[string]string
+		args     []string
+		want     func(c *testConfig)
+		wantArgs []string
+		wantErr  string
+	}{
+		{
+			name: "defaults",
+			want: func(c *testConfig) {},
+		},
+		{
+			name: "yaml file",
+			files: map[string]string{"test.yaml": `
+endpoint: file:1
+seed: true
+timeout: 2s
+ratio: 0.5
+roles: [a, b]
+timeouts:
+  ListRaces: 1s
+tls:
+  enabled: true
+  cert-file: file.pem
+`},
+			args: []string{"-config", "test.yaml"},
+			want: func(c *testConfig) {
+				c.Endpoint = "file:1"
+				c.Seed = true
+				c.Timeout = 2 * time.Second
+				c.Ratio = 0.5
+				c.Roles = []string{"a", "b"}
+				c.Timeouts = map[string]time.Duration{"ListRaces": time.Second}
+				c.TLS = testTLS{Enabled: true, CertFile: "file.pem"}
+			},
+		},
+		{
+			name: "toml file named by the environment",
+			files: map[string]string{"test.toml": `
+endpoint = "file:1"
+retries = 5
+
+[limits]
+burst = 10
+
+[tls]
+cert-file = "file.pem"
+`},
+			env: map[string]string{"TEST_CONFIG": "test.toml"},
+			want: func(c *testConfig) {
+				c.Endpoint = "file:1"
+				c.Retries = 5
+				c.Limits = map[string]int{"burst": 10}
+				c.TLS.CertFile = "file.pem"
+			},
+		},
+		{
+			name: "environment over file",
+			files: map[string]string{"test.yaml": `
+endpoint: file:1
+retries: 5
+tls:
+  cert-file: file.pem
+`},
+			env: map[string]string{
+				"TEST_ENDPOINT":      "env:1",
+				"TEST_TLS_CERT_FILE": "env.pem",
+				"TEST_TLS_ENABLED":   "true",
+			},
+			args: []string{"-config", "test.yaml"},
+			want: func(c *testConfig) {
+				c.Endpoint = "env:1"
+				c.Retries = 5
+				c.TLS = testTLS{Enabled: true, CertFile: "env.pem"}
+			},
+		},
+		{
+			name:  "flags over environment and file",
+			files: map[string]string{"test.yaml": "endpoint: file:1\nseed: true\n"},
+			env: map[string]string{
+				"TEST_ENDPOINT": "env:1",
+				"TEST_TIMEOUT":  "5s",
+				"TEST_CONFIG":   "missing.yaml",
+			},
+			args: []string{"-config", "test.yaml", "-endpoint", "flag:1", "-seed=false", "-tls.enabled"},
+			want: func(c *testConfig) {
+				c.Endpoint = "flag:1"
+				c.Timeout = 5 * time.Second
+				c.TLS.Enabled = true
+			},
+		},
+		{
+			name:     "remaining arguments",
+			args:     []string{"-seed", "migrate", "up", "-retries", "1"},
+			want:     func(c *testConfig) { c.Seed = true },
+			wantArgs: []string{"migrate", "up", "-retries", "1"},
+		},
+		{
+			name: "lists and maps",
+			env:  map[string]string{"TEST_LIMITS": "burst=10"},
+			args: []string{"-roles", "a, ,b,", "-timeouts", "ListRaces=1s, WatchRaces = 10s,"},
+			want: func(c *testConfig) {
+				c.Roles = []string{"a", "b"}
+				c.Timeouts = map[string]time.Duration{"ListRaces": time.Second, "WatchRaces": 10 * time.Second}
+				c.Limits = map[string]int{"burst": 10}
+			},
+		},
+		{
+			name: "emptied list",
+			args: []string{"-roles", ""},
+			want: func(c *testConfig) { c.Roles = nil },
+		},
+		{
+			name:    "invalid flag",
+			args:    []string{"-timeout", "soon"},
+			wantErr: `invalid value "soon" for flag -timeout`,
+		},
+		{
+			name:    "unknown flag",
+			args:    []string{"-bogus"},
+			wantErr: "flag provided but not defined: -bogus",
+		},
+		{
+			name:    "invalid environment variable",
+			env:     map[string]string{"TEST_RETRIES": "many"},
+			wantErr: `invalid TEST_RETRIES "many"`,
+		},
+		{
+			name:    "invalid map pair",
+			env:     map[string]string{"TEST_TIMEOUTS": "ListRaces"},
+			wantErr: `"ListRaces" must be <key>=<value>`,
+		},
+		{
+			name:    "invalid map value",
+			args:    []string{"-limits", "burst=lots"},
+			wantErr: `invalid value "burst=lots" for flag -limits`,
+		},
+		{
+			name:    "unknown file key",
+			files:   map[string]string{"test.yaml": "tls:\n  key-file: key.pem\n"},
+			args:    []string{"-config", "test.yaml"},
+			wantErr: "unknown key tls.key-file",
+		},
+		{
+			name:    "invalid file value",
+			files:   map[string]string{"test.yaml": "timeout: soon\n"},
+			args:    []string{"-config", "test.yaml"},
+			wantErr: "config file test.yaml: invalid timeout",
+		},
+		{
+			name:    "unknown file format",
+			files:   map[string]string{"test.json": "{}"},
+			args:    []string{"-config", "test.json"},
+			wantErr: `unknown format ".json"`,
+		},
+		{
+			name:    "missing file",
+			args:    []string{"-config", "missing.yaml"},
+			wantErr: "config file missing.yaml",
+		},
+		{
+			name:    "validated",
+			args:    []string{"-retries", "-1"},
+			wantErr: "invalid config: retries must not be negative",
+		},
+		{
+			name:  "validated after the file",
+			files: map[string]string{"test.yaml": "retries: -1\n"},
+			args:  []string{"-config", "test.yaml", "-retries", "2"},
+			want:  func(c *testConfig) { c.Retries = 2 },
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tc.files {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+			}
+
+			// Files are named relative to the test's directory.
+			wd, err := os.Getwd()
+			require.NoError(t, err)
+			require.NoError(t, os.Chdir(dir))
+			t.Cleanup(func() { os.Chdir(wd) })
+
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+
+			cfg := defaultTestConfig()
+
+			args, err := Load("test", tc.args, &cfg)
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			want := defaultTestConfig()
+			tc.want(&want)
+
+			assert.Equal(t, want, cfg)
+			assert.Equal(t, tc.wantArgs, nilIfEmpty(args))
+		})
+	}
+}
+
+func nilIfEmpty(args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+
+	return args
+}
+
+func TestLoadHelp(t *testing.T) {
+	cfg := defaultTestConfig()
+
+	_, err := Load("test", []string{"-h"}, &cfg)
+	assert.True(t, IsHelp(err))
+
+	assert.False(t, IsHelp(errors.New("other")))
+}
+
+func TestLoadUnsupported(t *testing.T) {
+	_, err := Load("test", nil, defaultTestConfig())
+	assert.ErrorContains(t, err, "must be a pointer to a struct")
+
+	var unsupported struct {
+		Ports []int `config:"ports"`
+	}
+
+	_, err = Load("test", nil, &unsupported)
+	assert.ErrorContains(t, err, "config field ports has unsupported type []int")
+
+	var nested struct {
+		Lists map[string][]string `config:"lists"`
+	}
+
+	_, err = Load("test", nil, &nested)
+	assert.ErrorContains(t, err, "unsupported type")
+}
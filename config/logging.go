@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LogLevels are the accepted log levels, from most to least verbose.
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// LogFormats are the accepted log formats: logfmt-style text, or a JSON object
+// per entry.
+var LogFormats = []string{"text", "json"}
+
+// ValidateLogLevel returns an error unless level is one of LogLevels.
+func ValidateLogLevel(level string) error {
+	return validateOneOf("log-level", level, LogLevels)
+}
+
+// ValidateLogFormat returns an error unless format is one of LogFormats.
+func ValidateLogFormat(format string) error {
+	return validateOneOf("log-format", format, LogFormats)
+}
+
+// SetupLogging configures the standard logger to write entries of at least the
+// given level in the given format, which must be valid.
+func SetupLogging(level, format string) error {
+	l, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	log.SetLevel(l)
+
+	if format == "json" {
+		log.SetFormatter(&log.JSONFormatter{})
+	} else {
+		log.SetFormatter(&log.TextFormatter{})
+	}
+
+	return nil
+}
+
+func validateOneOf(name, value string, values []string) error {
+	for _, v := range values {
+		if value == v {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s must be one of %s", name, strings.Join(values, ", "))
+}
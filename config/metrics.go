@@ -0,0 +1,43 @@
+package config
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// ServeMetrics serves the metrics of the gatherer on /metrics at endpoint
+// until the returned server is closed. It returns nil if endpoint is empty.
+func ServeMetrics(endpoint string, gatherer prometheus.Gatherer) (*http.Server, error) {
+	if endpoint == "" {
+		return nil, nil
+	}
+
+	conn, err := net.Listen("tcp", endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := server.Serve(conn); !errors.Is(err, http.ErrServerClosed) {
+			log.WithError(err).Error("failed serving metrics")
+		}
+	}()
+
+	log.Infof("metrics server listening on: %s", endpoint)
+
+	return server, nil
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLS configures transport security for either a server or a client.
+type TLS struct {
+	Enabled    bool   `config:"enabled" usage:"enable TLS"`
+	CertFile   string `config:"cert-file" usage:"PEM certificate, required by servers and presented by clients for mutual TLS"`
+	KeyFile    string `config:"key-file" usage:"PEM private key of the certificate"`
+	CAFile     string `config:"ca-file" usage:"PEM CA bundle to verify peers against; servers then require client certificates"`
+	ServerName string `config:"server-name" usage:"name to verify the server certificate against, for clients"`
+}
+
+// ValidateServer checks the settings of a server.
+func (t TLS) ValidateServer() error {
+	if !t.Enabled {
+		return nil
+	}
+
+	if t.CertFile == "" || t.KeyFile == "" {
+		return errors.New("cert-file and key-file are required when TLS is enabled")
+	}
+
+	return nil
+}
+
+// ValidateClient checks the settings of a client.
+func (t TLS) ValidateClient() error {
+	if !t.Enabled {
+		return nil
+	}
+
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("cert-file and key-file must be given together")
+	}
+
+	return nil
+}
+
+// ServerConfig builds the TLS configuration of a server, or returns nil if TLS
+// is disabled.
+func (t TLS) ServerConfig() (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if t.CAFile != "" {
+		if cfg.ClientCAs, err = loadCAs(t.CAFile); err != nil {
+			return nil, err
+		}
+
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// ClientConfig builds the TLS configuration of a client, or returns nil if TLS
+// is disabled. Servers are verified against the system roots unless a CA file
+// is given.
+func (t TLS) ClientConfig() (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		ServerName: t.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if t.CAFile != "" {
+		var err error
+		if cfg.RootCAs, err = loadCAs(t.CAFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+func loadCAs(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("%s contains no PEM certificates", path)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// Tracing exporters.
+const (
+	TracingExporterNone   = "none"
+	TracingExporterOTLP   = "otlp"
+	TracingExporterStdout = "stdout"
+	TracingExporterFile   = "file"
+)
+
+// TracingFlushTimeout bounds how long pending spans may take to export on
+// shutdown.
+const TracingFlushTimeout = 5 * time.Second
+
+// Tracing configures the export of OpenTelemetry trace spans.
+type Tracing struct {
+	Exporter    string  `config:"exporter" usage:"where to export spans: none, otlp, stdout or file"`
+	Endpoint    string  `config:"endpoint" usage:"OTLP gRPC collector endpoint, for the otlp exporter"`
+	Insecure    bool    `config:"insecure" usage:"connect to the OTLP collector without TLS"`
+	File        string  `config:"file" usage:"file spans are appended to as JSON, for the file exporter"`
+	SampleRatio float64 `config:"sample-ratio" usage:"fraction of traces to sample, unless the caller has already decided"`
+}
+
+// DefaultTracing returns the tracing settings used where nothing else is set,
+// which sample every trace but export none.
+func DefaultTracing() Tracing {
+	return Tracing{
+		Exporter:    TracingExporterNone,
+		SampleRatio: 1,
+	}
+}
+
+// Validate checks the settings.
+func (t Tracing) Validate() error {
+	switch t.Exporter {
+	case TracingExporterNone, TracingExporterStdout:
+	case TracingExporterOTLP:
+		if t.Endpoint == "" {
+			return errors.New("endpoint is required by the otlp exporter")
+		}
+	case TracingExporterFile:
+		if t.File == "" {
+			return errors.New("file is required by the file exporter")
+		}
+	default:
+		return fmt.Errorf("unknown exporter %q, expected none, otlp, stdout or file", t.Exporter)
+	}
+
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		return errors.New("sample-ratio must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// Start installs the global tracer provider of the named service, exporting
+// its spans, and the W3C trace context propagator. Spans are still created,
+// and propagated, with the none exporter, but not exported. The returned
+// function flushes any pending spans.
+func (t Tracing) Start(ctx context.Context, service string) (func(context.Context) error, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String(service),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(t.SampleRatio))),
+	}
+
+	closeFile := func() error { return nil }
+
+	switch t.Exporter {
+	case TracingExporterOTLP:
+		clientOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(t.Endpoint)}
+		if t.Insecure {
+			clientOpts = append(clientOpts, otlptracegrpc.WithInsecure())
+		}
+
+		exporter, err := otlptracegrpc.New(ctx, clientOpts...)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	case TracingExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, sdktrace.WithSyncer(exporter))
+	case TracingExporterFile:
+		f, err := os.OpenFile(t.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+		closeFile = f.Close
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if cerr := closeFile(); err == nil {
+			err = cerr
+		}
+
+		return err
+	}, nil
+}
+
+// FlushTracing exports any spans still pending on shutdown, through the
+// function returned by Start, logging any failure.
+func FlushTracing(shutdown func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), TracingFlushTimeout)
+	defer cancel()
+
+	if err := shutdown(ctx); err != nil {
+		log.WithError(err).Error("failed flushing traces")
+	}
+}
@@ -0,0 +1,254 @@
+// Package auth authorises the RPCs served by the racing service, according to
+// the principal the gateway authenticated each request as.
+//
+// The principal is trusted as given in the request metadata, so the service
+// must only be reachable by the gateway, e.g. by requiring client certificates.
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// SubjectKey is the metadata key of the subject the gateway authenticated
+	// a request as, which is absent for anonymous requests.
+	SubjectKey = "x-principal-subject"
+
+	// RolesKey is the metadata key of the comma-separated roles of the
+	// principal.
+	RolesKey = "x-principal-roles"
+
+	// requestIDKey is the metadata key of the ID the gateway assigns each
+	// request, which is logged with rejections.
+	requestIDKey = "x-request-id"
+)
+
+// writeMethods are the RPCs which modify races, which only trading roles may
+// call.
+var writeMethods = map[string]bool{
+	fullMethod("CreateRace"):       true,
+	fullMethod("BatchCreateRaces"): true,
+	fullMethod("UpdateRace"):       true,
+	fullMethod("DeleteRace"):       true,
+}
+
+func fullMethod(name string) string {
+	return "/" + racing.Racing_ServiceDesc.ServiceName + "/" + name
+}
+
+// Principal is who a request was made by.
+type Principal struct {
+	// Subject identifies the principal, or is empty if it is anonymous.
+	Subject string
+
+	// Roles are the roles granted to the principal.
+	Roles []string
+}
+
+// Anonymous reports whether the request was made without credentials.
+func (p Principal) Anonymous() bool {
+	return p.Subject == ""
+}
+
+// HasAnyRole reports whether the principal has any of the roles.
+func (p Principal) HasAnyRole(roles []string) bool {
+	for _, role := range p.Roles {
+		for _, r := range roles {
+			if role == r {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// FromMetadata returns the principal the gateway forwarded in the metadata of
+// an incoming request.
+func FromMetadata(ctx context.Context) Principal {
+	var p Principal
+
+	if subjects := metadata.ValueFromIncomingContext(ctx, SubjectKey); len(subjects) > 0 {
+		p.Subject = subjects[0]
+	}
+
+	for _, roles := range metadata.ValueFromIncomingContext(ctx, RolesKey) {
+		for _, role := range strings.Split(roles, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				p.Roles = append(p.Roles, role)
+			}
+		}
+	}
+
+	return p
+}
+
+// tradingKey is the context key recording whether the principal of a request
+// has a trading role.
+type tradingKey struct{}
+
+// principalKey is the context key of the principal a request was authorised
+// as.
+type principalKey struct{}
+
+// FromContext returns the principal a request was authorised as. It is
+// anonymous unless the request was authorised.
+func FromContext(ctx context.Context) Principal {
+	principal, _ := ctx.Value(principalKey{}).(Principal)
+	return principal
+}
+
+// CanViewHidden reports whether the principal of a request may see races which
+// are not visible. It is false unless the request was authorised.
+func CanViewHidden(ctx context.Context) bool {
+	trading, _ := ctx.Value(tradingKey{}).(bool)
+	return trading
+}
+
+// Authorizer enforces the access of principals: anyone may read visible races,
+// but only trading roles may read hidden races or modify races.
+type Authorizer struct {
+	tradingRoles []string
+}
+
+// NewAuthorizer returns an Authorizer granting the trading roles full access.
+func NewAuthorizer(tradingRoles []string) *Authorizer {
+	return &Authorizer{tradingRoles: tradingRoles}
+}
+
+// UnaryServerInterceptor authorises each unary RPC.
+func (a *Authorizer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, trading, err := a.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		if !trading {
+			if err := restrictToVisible(req); err != nil {
+				logRejection(ctx, info.FullMethod, err)
+				return nil, err
+			}
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor authorises each streaming RPC, such as WatchRaces.
+func (a *Authorizer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, trading, err := a.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx, trading: trading})
+	}
+}
+
+// authorize checks the principal of a request may call method, and returns its
+// context recording the principal and whether it has a trading role.
+func (a *Authorizer) authorize(ctx context.Context, method string) (context.Context, bool, error) {
+	principal := FromMetadata(ctx)
+	trading := principal.HasAnyRole(a.tradingRoles)
+
+	ctx = context.WithValue(ctx, principalKey{}, principal)
+
+	if writeMethods[method] && !trading {
+		err := status.Errorf(codes.PermissionDenied, "%s may not modify races", principal.Subject)
+		if principal.Anonymous() {
+			err = status.Error(codes.Unauthenticated, "credentials are required")
+		}
+
+		logRejection(ctx, method, err)
+
+		return nil, false, err
+	}
+
+	return context.WithValue(ctx, tradingKey{}, trading), trading, nil
+}
+
+// logRejection logs an RPC the principal of ctx was refused. RPCs are logged
+// once they are authorised, so rejected ones are logged here instead.
+func logRejection(ctx context.Context, method string, err error) {
+	fields := log.Fields{
+		"method": method,
+		"code":   status.Code(err).String(),
+	}
+
+	if ids := metadata.ValueFromIncomingContext(ctx, requestIDKey); len(ids) > 0 {
+		fields["request_id"] = ids[0]
+	}
+
+	if span := trace.SpanContextFromContext(ctx); span.HasTraceID() {
+		fields["trace_id"] = span.TraceID().String()
+	}
+
+	if subject := FromContext(ctx).Subject; subject != "" {
+		fields["subject"] = subject
+	}
+
+	log.WithFields(fields).WithError(err).Warn("rpc rejected")
+}
+
+// restrictToVisible limits the races a request lists to those which are
+// visible, refusing requests explicitly for hidden races.
+func restrictToVisible(req interface{}) error {
+	var filter **racing.ListRacesRequestFilter
+
+	switch req := req.(type) {
+	case *racing.ListRacesRequest:
+		filter = &req.Filter
+	case *racing.WatchRacesRequest:
+		filter = &req.Filter
+	default:
+		return nil
+	}
+
+	if *filter == nil {
+		*filter = &racing.ListRacesRequestFilter{}
+	}
+
+	if (*filter).Visible != nil && !(*filter).GetVisible() {
+		return status.Error(codes.PermissionDenied, "hidden races may only be listed by trading roles")
+	}
+
+	visible := true
+	(*filter).Visible = &visible
+
+	return nil
+}
+
+// authorizedStream carries the context of an authorised stream, and restricts
+// the messages it receives to visible races unless it may see hidden ones.
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	trading bool
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authorizedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	if s.trading {
+		return nil
+	}
+
+	return restrictToVisible(m)
+}
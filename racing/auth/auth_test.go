@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// incoming returns a context with the metadata the gateway forwards for a
+// principal, which is anonymous without a subject.
+func incoming(subject string, roles string) context.Context {
+	ctx := context.Background()
+	if subject == "" {
+		return ctx
+	}
+
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(SubjectKey, subject, RolesKey, roles))
+}
+
+func TestFromMetadata(t *testing.T) {
+	assert.Equal(t, Principal{}, FromMetadata(context.Background()))
+	assert.True(t, FromMetadata(context.Background()).Anonymous())
+
+	p := FromMetadata(incoming("alice", " trader, ,viewer "))
+	assert.Equal(t, Principal{Subject: "alice", Roles: []string{"trader", "viewer"}}, p)
+	assert.False(t, p.Anonymous())
+	assert.True(t, p.HasAnyRole([]string{"admin", "viewer"}))
+	assert.False(t, p.HasAnyRole([]string{"admin"}))
+}
+
+func TestRestrictToVisible(t *testing.T) {
+	visible, hidden := true, false
+
+	for _, tc := range []struct {
+		name     string
+		req      interface{}
+		want     interface{}
+		wantCode codes.Code
+	}{
+		{
+			name: "no filter",
+			req:  &racing.ListRacesRequest{},
+			want: &racing.ListRacesRequest{Filter: &racing.ListRacesRequestFilter{Visible: &visible}},
+		},
+		{
+			name: "filter without visible",
+			req:  &racing.ListRacesRequest{Filter: &racing.ListRacesRequestFilter{MeetingIds: []int64{1}}},
+			want: &racing.ListRacesRequest{Filter: &racing.ListRacesRequestFilter{MeetingIds: []int64{1}, Visible: &visible}},
+		},
+		{
+			name: "visible",
+			req:  &racing.ListRacesRequest{Filter: &racing.ListRacesRequestFilter{Visible: &visible}},
+			want: &racing.ListRacesRequest{Filter: &racing.ListRacesRequestFilter{Visible: &visible}},
+		},
+		{
+			name:     "hidden",
+			req:      &racing.ListRacesRequest{Filter: &racing.ListRacesRequestFilter{Visible: &hidden}},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name: "watch",
+			req:  &racing.WatchRacesRequest{},
+			want: &racing.WatchRacesRequest{Filter: &racing.ListRacesRequestFilter{Visible: &visible}},
+		},
+		{
+			name:     "watch hidden",
+			req:      &racing.WatchRacesRequest{Filter: &racing.ListRacesRequestFilter{Visible: &hidden}},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name: "other request",
+			req:  &racing.GetRaceRequest{Id: 1},
+			want: &racing.GetRaceRequest{Id: 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := restrictToVisible(tc.req)
+
+			if tc.wantCode != codes.OK {
+				assert.Equal(t, tc.wantCode, status.Code(err))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.True(t, proto.Equal(tc.want.(proto.Message), tc.req.(proto.Message)), "got %v", tc.req)
+		})
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := NewAuthorizer([]string{"trader"}).UnaryServerInterceptor()
+
+	for _, tc := range []struct {
+		name        string
+		ctx         context.Context
+		method      string
+		req         interface{}
+		wantCode    codes.Code
+		wantHidden  bool
+		wantSubject string
+	}{
+		{
+			name:   "anonymous read",
+			ctx:    incoming("", ""),
+			method: fullMethod("ListRaces"),
+			req:    &racing.ListRacesRequest{},
+		},
+		{
+			name:        "non-trader read",
+			ctx:         incoming("alice", "viewer"),
+			method:      fullMethod("GetRace"),
+			req:         &racing.GetRaceRequest{Id: 1},
+			wantSubject: "alice",
+		},
+		{
+			name:        "trader read",
+			ctx:         incoming("bob", "viewer,trader"),
+			method:      fullMethod("ListRaces"),
+			req:         &racing.ListRacesRequest{},
+			wantHidden:  true,
+			wantSubject: "bob",
+		},
+		{
+			name:     "non-trader listing hidden races",
+			ctx:      incoming("alice", "viewer"),
+			method:   fullMethod("ListRaces"),
+			req:      &racing.ListRacesRequest{Filter: &racing.ListRacesRequestFilter{Visible: proto.Bool(false)}},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "anonymous write",
+			ctx:      incoming("", ""),
+			method:   fullMethod("DeleteRace"),
+			req:      &racing.DeleteRaceRequest{Id: 1},
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "non-trader write",
+			ctx:      incoming("alice", "viewer"),
+			method:   fullMethod("CreateRace"),
+			req:      &racing.CreateRaceRequest{},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:        "trader write",
+			ctx:         incoming("bob", "trader"),
+			method:      fullMethod("UpdateRace"),
+			req:         &racing.UpdateRaceRequest{},
+			wantHidden:  true,
+			wantSubject: "bob",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			called := false
+
+			_, err := interceptor(tc.ctx, tc.req, &grpc.UnaryServerInfo{FullMethod: tc.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+
+				assert.Equal(t, tc.wantHidden, CanViewHidden(ctx))
+				assert.Equal(t, tc.wantSubject, FromContext(ctx).Subject)
+
+				if list, ok := req.(*racing.ListRacesRequest); ok && !tc.wantHidden {
+					assert.True(t, list.Filter.GetVisible(), "unrestricted filter %v", list.Filter)
+				}
+
+				return nil, nil
+			})
+
+			assert.Equal(t, tc.wantCode, status.Code(err))
+			assert.Equal(t, tc.wantCode == codes.OK, called)
+		})
+	}
+
+	assert.False(t, CanViewHidden(incoming("bob", "trader")), "unauthorised requests may not view hidden races")
+}
+
+// recvStream is a server stream receiving a single message.
+type recvStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	msg proto.Message
+}
+
+func (s *recvStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *recvStream) RecvMsg(m interface{}) error {
+	if s.msg == nil {
+		return io.EOF
+	}
+
+	proto.Merge(m.(proto.Message), s.msg)
+	s.msg = nil
+
+	return nil
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	interceptor := NewAuthorizer([]string{"trader"}).StreamServerInterceptor()
+	info := &grpc.StreamServerInfo{FullMethod: fullMethod("WatchRaces"), IsServerStream: true}
+
+	watch := func(ctx context.Context, req *racing.WatchRacesRequest) (*racing.WatchRacesRequest, bool, error) {
+		var (
+			received = &racing.WatchRacesRequest{}
+			hidden   bool
+		)
+
+		err := interceptor(nil, &recvStream{ctx: ctx, msg: req}, info, func(_ interface{}, stream grpc.ServerStream) error {
+			hidden = CanViewHidden(stream.Context())
+			return stream.RecvMsg(received)
+		})
+
+		return received, hidden, err
+	}
+
+	received, hidden, err := watch(incoming("alice", "viewer"), &racing.WatchRacesRequest{})
+	require.NoError(t, err)
+	assert.False(t, hidden)
+	assert.True(t, received.Filter.GetVisible())
+
+	_, _, err = watch(incoming("", ""), &racing.WatchRacesRequest{Filter: &racing.ListRacesRequestFilter{Visible: proto.Bool(false)}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	received, hidden, err = watch(incoming("bob", "trader"), &racing.WatchRacesRequest{Filter: &racing.ListRacesRequestFilter{Visible: proto.Bool(false)}})
+	require.NoError(t, err)
+	assert.True(t, hidden)
+	assert.False(t, received.Filter.GetVisible())
+}
@@ -0,0 +1,33 @@
+// Package clock provides an injectable source of the current time, so that
+// anything derived from "now" can be frozen in tests.
+package clock
+
+import "time"
+
+// Clock tells the current time.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// New returns a Clock backed by the system time.
+func New() Clock {
+	return systemClock{}
+}
+
+// Frozen returns a Clock that always reports t.
+func Frozen(t time.Time) Clock {
+	return frozenClock(t)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+type frozenClock time.Time
+
+func (c frozenClock) Now() time.Time {
+	return time.Time(c)
+}
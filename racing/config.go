@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"git.neds.sh/matty/entain/config"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Config is the configuration of the racing service. See config.Load for how
+// it is set.
+type Config struct {
+	GRPCEndpoint     string                   `config:"grpc-endpoint" usage:"gRPC server endpoint"`
+	TLS              config.TLS               `config:"tls"`
+	DBDSN            string                   `config:"db-dsn" usage:"racing database DSN, either sqlite3://<path> or postgres://..."`
+	Seed             bool                     `config:"seed" usage:"seed the database with dummy meetings and races"`
+	QueryTimeout     time.Duration            `config:"query-timeout" usage:"default time each RPC may spend querying the database, or 0 for none"`
+	RPCQueryTimeouts map[string]time.Duration `config:"rpc-query-timeouts" usage:"per-RPC query timeouts overriding query-timeout, e.g. ListRaces=2s,WatchRaces=10s"`
+	LogLevel         string                   `config:"log-level" usage:"minimum level of logs to write: debug, info, warn or error"`
+	LogFormat        string                   `config:"log-format" usage:"format of logs: text or json"`
+	TradingRoles     []string                 `config:"trading-roles" usage:"roles which may see hidden races and create, update or delete races"`
+
+	DrainTimeout        time.Duration  `config:"drain-timeout" usage:"time in-flight requests may take to finish on shutdown"`
+	HealthCheckInterval time.Duration  `config:"health-check-interval" usage:"how often the database is pinged to report health"`
+	MetricsEndpoint     string         `config:"metrics-endpoint" usage:"endpoint serving Prometheus metrics on /metrics, or empty to disable them"`
+	Tracing             config.Tracing `config:"tracing"`
+}
+
+// defaultConfig returns the configuration used where nothing else is set.
+func defaultConfig() Config {
+	return Config{
+		GRPCEndpoint: "localhost:9000",
+		DBDSN:        "sqlite3://./db/racing.db",
+		Seed:         true,
+		QueryTimeout: 5 * time.Second,
+		LogLevel:     "info",
+		LogFormat:    "text",
+		TradingRoles: []string{"trader"},
+
+		DrainTimeout:        15 * time.Second,
+		HealthCheckInterval: 5 * time.Second,
+		MetricsEndpoint:     "localhost:9100",
+		Tracing:             config.DefaultTracing(),
+	}
+}
+
+func (c *Config) Validate() error {
+	if c.GRPCEndpoint == "" {
+		return errors.New("grpc-endpoint is required")
+	}
+
+	if err := c.TLS.ValidateServer(); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+
+	if err := c.Tracing.Validate(); err != nil {
+		return fmt.Errorf("tracing: %w", err)
+	}
+
+	if c.DBDSN == "" {
+		return errors.New("db-dsn is required")
+	}
+
+	if c.QueryTimeout < 0 {
+		return errors.New("query-timeout must not be negative")
+	}
+
+	if c.DrainTimeout < 0 {
+		return errors.New("drain-timeout must not be negative")
+	}
+
+	if c.HealthCheckInterval <= 0 {
+		return errors.New("health-check-interval must be positive")
+	}
+
+	methods := racing.File_racing_racing_proto.Services().ByName("Racing").Methods()
+
+	for rpc, timeout := range c.RPCQueryTimeouts {
+		if methods.ByName(protoreflect.Name(rpc)) == nil {
+			return fmt.Errorf("rpc-query-timeouts: unknown RPC %q", rpc)
+		}
+
+		if timeout < 0 {
+			return fmt.Errorf("rpc-query-timeouts: %s must not be negative", rpc)
+		}
+	}
+
+	if err := config.ValidateLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+
+	return config.ValidateLogFormat(c.LogFormat)
+}
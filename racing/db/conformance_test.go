@@ -0,0 +1,692 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/clock"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The conformance suite runs every case against each implementation of
+// RacesRepo and MeetingsRepo, which must behave identically.
+
+// conformanceNow is the instant the repositories under test are frozen at.
+var conformanceNow = time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)
+
+// conformanceRepos is an implementation of the repositories under test, with
+// the means to load the runners and results they cannot write themselves.
+type conformanceRepos struct {
+	races    RacesRepo
+	meetings MeetingsRepo
+
+	// putRunners stores the runners of a race.
+	putRunners func(t *testing.T, raceID int64, runners []*racing.Runner)
+
+	// putResult stores the result of a race. Placings name their runners by
+	// saddle cloth number.
+	putResult func(t *testing.T, result *racing.RaceResult)
+}
+
+// repoImplementations opens each implementation, holding the meetings.
+var repoImplementations = []struct {
+	name string
+	open func(t *testing.T, meetings []*racing.Meeting) *conformanceRepos
+}{
+	{"sql", openSQLRepos},
+	{"memory", openMemoryRepos},
+}
+
+func openSQLRepos(t *testing.T, meetings []*racing.Meeting) *conformanceRepos {
+	db := openTestDB(t)
+
+	for _, meeting := range meetings {
+		_, err := db.Exec(
+			`INSERT INTO meetings(id, venue, state, country, race_type, track_condition, date) VALUES (?,?,?,?,?,?,?)`,
+			meeting.Id, meeting.Venue, meeting.State, meeting.Country, int32(meeting.RaceType), meeting.TrackCondition, meeting.Date,
+		)
+		require.NoError(t, err)
+	}
+
+	races := NewRacesRepo(db, clock.Frozen(conformanceNow), RepoOptions{})
+	meetingsRepo := NewMeetingsRepo(db, RepoOptions{})
+
+	require.NoError(t, meetingsRepo.Init())
+	require.NoError(t, races.Init())
+
+	return &conformanceRepos{
+		races:    races,
+		meetings: meetingsRepo,
+		putRunners: func(t *testing.T, raceID int64, runners []*racing.Runner) {
+			for _, runner := range runners {
+				_, err := db.Exec(
+					`INSERT INTO runners(race_id, barrier, saddle_cloth_number, name, jockey, trainer, weight, scratched) VALUES (?,?,?,?,?,?,?,?)`,
+					raceID, runner.Barrier, runner.SaddleClothNumber, runner.Name, runner.Jockey, runner.Trainer, runner.Weight, runner.Scratched,
+				)
+				require.NoError(t, err)
+			}
+		},
+		putResult: func(t *testing.T, result *racing.RaceResult) {
+			_, err := db.Exec(
+				`INSERT INTO race_results(race_id, official, protest, abandoned) VALUES (?,?,?,?)`,
+				result.RaceId, result.Official, result.Protest, result.Abandoned,
+			)
+			require.NoError(t, err)
+
+			for _, placing := range result.Placings {
+				_, err := db.Exec(
+					`INSERT INTO result_placings(race_id, runner_id, position, margin)
+					SELECT race_id, id, ?, ? FROM runners WHERE race_id = ? AND saddle_cloth_number = ?`,
+					placing.Position, placing.Margin, result.RaceId, placing.SaddleClothNumber,
+				)
+				require.NoError(t, err)
+			}
+
+			for _, dividend := range result.Dividends {
+				selections, err := json.Marshal(dividend.Selections)
+				require.NoError(t, err)
+
+				_, err = db.Exec(
+					`INSERT INTO result_dividends(race_id, bet_type, selections, amount) VALUES (?,?,?,?)`,
+					result.RaceId, int32(dividend.BetType), string(selections), dividend.Amount,
+				)
+				require.NoError(t, err)
+			}
+		},
+	}
+}
+
+func openMemoryRepos(t *testing.T, meetings []*racing.Meeting) *conformanceRepos {
+	meetingsRepo := NewMemoryMeetingsRepo(meetings...)
+	races := NewMemoryRacesRepo(meetingsRepo, clock.Frozen(conformanceNow))
+
+	return &conformanceRepos{
+		races:    races,
+		meetings: meetingsRepo,
+		putRunners: func(t *testing.T, raceID int64, runners []*racing.Runner) {
+			stored := make([]*racing.Runner, 0, len(runners))
+
+			for _, runner := range runners {
+				runner = proto.Clone(runner).(*racing.Runner)
+				runner.Id = raceID*100 + runner.SaddleClothNumber
+				stored = append(stored, runner)
+			}
+
+			require.NoError(t, races.PutRunners(raceID, stored))
+		},
+		putResult: func(t *testing.T, result *racing.RaceResult) {
+			result = proto.Clone(result).(*racing.RaceResult)
+
+			for _, placing := range result.Placings {
+				placing.RunnerId = result.RaceId*100 + placing.SaddleClothNumber
+			}
+
+			require.NoError(t, races.PutResult(result))
+		},
+	}
+}
+
+// conformanceMeetings are the meetings races are held at in the suite.
+// Meeting 4, which some races are held at, does not exist.
+var conformanceMeetings = []*racing.Meeting{
+	{Id: 1, Venue: "Flemington", State: "VIC", Country: "AUS", RaceType: racing.Meeting_THOROUGHBRED, TrackCondition: "Good 4", Date: "2021-03-02"},
+	{Id: 2, Venue: "Menangle", State: "NSW", Country: "AUS", RaceType: racing.Meeting_HARNESS, TrackCondition: "Good 3", Date: "2021-03-02"},
+	{Id: 3, Venue: "Sandown Park", State: "VIC", Country: "AUS", RaceType: racing.Meeting_GREYHOUND, TrackCondition: "Soft 5", Date: "2021-03-01"},
+}
+
+// conformanceRace describes a race loaded into the repositories. The races are
+// created in order, so are assigned IDs from 1.
+type conformanceRace struct {
+	meetingID int64
+	name      string
+	number    int64
+	visible   bool
+	start     time.Duration
+	result    *racing.RaceResult
+}
+
+// Names are chosen so that byte order differs from case-insensitive and
+// accent-aware collations.
+var conformanceRaces = []conformanceRace{
+	{1, "Bravo", 1, true, -2 * time.Hour, &racing.RaceResult{Official: true}},
+	{1, "alpha", 2, true, -time.Hour, nil},
+	{2, "charlie", 1, false, time.Hour, nil},
+	{2, "Éclair", 2, true, time.Hour, nil},
+	{3, "éclair", 1, false, 2 * time.Hour, nil},
+	{3, "delta", 3, true, 0, nil},
+	{1, "echo", 3, true, -3 * time.Hour, &racing.RaceResult{Official: true, Abandoned: true}},
+	{4, "foxtrot", 1, true, 3 * time.Hour, nil},
+}
+
+// loadConformanceRaces opens an implementation, and loads the suite's races.
+func loadConformanceRaces(t *testing.T, open func(*testing.T, []*racing.Meeting) *conformanceRepos) *conformanceRepos {
+	t.Helper()
+
+	repos := open(t, conformanceMeetings)
+
+	races := make([]*racing.Race, 0, len(conformanceRaces))
+	for _, race := range conformanceRaces {
+		races = append(races, &racing.Race{
+			MeetingId:           race.meetingID,
+			Name:                race.name,
+			Number:              race.number,
+			Visible:             race.visible,
+			AdvertisedStartTime: timestamppb.New(conformanceNow.Add(race.start)),
+		})
+	}
+
+	created, err := repos.races.Create(context.Background(), races)
+	require.NoError(t, err)
+
+	for i, race := range created {
+		require.EqualValues(t, i+1, race.Id)
+
+		if result := conformanceRaces[i].result; result != nil {
+			result = proto.Clone(result).(*racing.RaceResult)
+			result.RaceId = race.Id
+
+			repos.putResult(t, result)
+		}
+	}
+
+	return repos
+}
+
+// raceIDs returns the IDs of races, in order.
+func raceIDs(races []*racing.Race) []int64 {
+	ids := []int64{}
+	for _, race := range races {
+		ids = append(ids, race.Id)
+	}
+
+	return ids
+}
+
+func TestConformanceList(t *testing.T) {
+	visible, hidden := true, false
+
+	for _, tc := range []struct {
+		name    string
+		filter  *racing.ListRacesRequestFilter
+		orderBy string
+		want    []int64
+	}{
+		{
+			name: "all",
+			want: []int64{7, 1, 2, 6, 3, 4, 5, 8},
+		},
+		{
+			name:   "meeting ids",
+			filter: &racing.ListRacesRequestFilter{MeetingIds: []int64{1, 3}},
+			want:   []int64{7, 1, 2, 6, 5},
+		},
+		{
+			name:   "unknown meeting id",
+			filter: &racing.ListRacesRequestFilter{MeetingIds: []int64{9}},
+			want:   []int64{},
+		},
+		{
+			name:   "visible",
+			filter: &racing.ListRacesRequestFilter{Visible: &visible},
+			want:   []int64{7, 1, 2, 6, 4, 8},
+		},
+		{
+			name:   "hidden",
+			filter: &racing.ListRacesRequestFilter{Visible: &hidden},
+			want:   []int64{3, 5},
+		},
+		{
+			name:   "open",
+			filter: &racing.ListRacesRequestFilter{Status: racing.Race_OPEN},
+			want:   []int64{3, 4, 5, 8},
+		},
+		{
+			name:   "closed, including starting now",
+			filter: &racing.ListRacesRequestFilter{Status: racing.Race_CLOSED},
+			want:   []int64{2, 6},
+		},
+		{
+			name:   "final",
+			filter: &racing.ListRacesRequestFilter{Status: racing.Race_FINAL},
+			want:   []int64{1},
+		},
+		{
+			name:   "interim",
+			filter: &racing.ListRacesRequestFilter{Status: racing.Race_INTERIM},
+			want:   []int64{},
+		},
+		{
+			name:   "abandoned",
+			filter: &racing.ListRacesRequestFilter{Status: racing.Race_ABANDONED},
+			want:   []int64{7},
+		},
+		{
+			name:   "race types",
+			filter: &racing.ListRacesRequestFilter{RaceTypes: []racing.Meeting_RaceType{racing.Meeting_HARNESS, racing.Meeting_GREYHOUND}},
+			want:   []int64{6, 3, 4, 5},
+		},
+		{
+			name:   "venues",
+			filter: &racing.ListRacesRequestFilter{Venues: []string{"Flemington", "Randwick"}},
+			want:   []int64{7, 1, 2},
+		},
+		{
+			name: "race types and venues",
+			filter: &racing.ListRacesRequestFilter{
+				RaceTypes: []racing.Meeting_RaceType{racing.Meeting_THOROUGHBRED},
+				Venues:    []string{"Menangle"},
+			},
+			want: []int64{},
+		},
+		{
+			name:   "combined",
+			filter: &racing.ListRacesRequestFilter{MeetingIds: []int64{2, 3}, Visible: &visible, Status: racing.Race_OPEN},
+			want:   []int64{4},
+		},
+		{
+			name:    "name, byte by byte",
+			orderBy: "name",
+			want:    []int64{1, 2, 3, 6, 7, 8, 4, 5},
+		},
+		{
+			name:    "name descending",
+			orderBy: "name desc",
+			want:    []int64{5, 4, 8, 7, 6, 3, 2, 1},
+		},
+		{
+			name:    "number descending, ties broken by id",
+			orderBy: "number desc",
+			want:    []int64{6, 7, 2, 4, 1, 3, 5, 8},
+		},
+		{
+			name:    "meeting descending then id descending",
+			orderBy: "meeting_id desc, id desc",
+			want:    []int64{8, 6, 5, 4, 3, 7, 2, 1},
+		},
+		{
+			name:    "visible then start time descending",
+			orderBy: "visible, advertised_start_time desc",
+			want:    []int64{5, 3, 8, 4, 6, 2, 1, 7},
+		},
+		{
+			name:    "start time, ties broken by id",
+			orderBy: "advertised_start_time",
+			want:    []int64{7, 1, 2, 6, 3, 4, 5, 8},
+		},
+		{
+			name:    "id descending",
+			orderBy: "id desc",
+			want:    []int64{8, 7, 6, 5, 4, 3, 2, 1},
+		},
+		{
+			name:    "filtered and ordered",
+			filter:  &racing.ListRacesRequestFilter{Visible: &visible},
+			orderBy: "name desc",
+			want:    []int64{4, 8, 7, 6, 2, 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, impl := range repoImplementations {
+				t.Run(impl.name, func(t *testing.T) {
+					ctx := context.Background()
+					repos := loadConformanceRaces(t, impl.open)
+
+					page, err := repos.races.List(ctx, tc.filter, ListOptions{OrderBy: tc.orderBy})
+					require.NoError(t, err)
+
+					assert.Equal(t, tc.want, raceIDs(page.Races))
+					assert.EqualValues(t, len(tc.want), page.TotalSize)
+					assert.Empty(t, page.NextPageToken)
+
+					// Paging through the races lists them in the same order.
+					var (
+						paged []*racing.Race
+						opts  = ListOptions{OrderBy: tc.orderBy, PageSize: 3}
+					)
+
+					for {
+						page, err := repos.races.List(ctx, tc.filter, opts)
+						require.NoError(t, err)
+
+						assert.LessOrEqual(t, len(page.Races), 3)
+						assert.EqualValues(t, len(tc.want), page.TotalSize)
+
+						paged = append(paged, page.Races...)
+
+						if page.NextPageToken == "" {
+							break
+						}
+
+						opts.PageToken = page.NextPageToken
+					}
+
+					assert.Equal(t, tc.want, raceIDs(paged))
+				})
+			}
+		})
+	}
+}
+
+func TestConformanceStatuses(t *testing.T) {
+	want := map[int64]racing.Race_Status{
+		1: racing.Race_FINAL,
+		2: racing.Race_CLOSED,
+		3: racing.Race_OPEN,
+		4: racing.Race_OPEN,
+		5: racing.Race_OPEN,
+		6: racing.Race_CLOSED,
+		7: racing.Race_ABANDONED,
+		8: racing.Race_OPEN,
+	}
+
+	for _, impl := range repoImplementations {
+		t.Run(impl.name, func(t *testing.T) {
+			ctx := context.Background()
+			repos := loadConformanceRaces(t, impl.open)
+
+			page, err := repos.races.List(ctx, nil, ListOptions{})
+			require.NoError(t, err)
+
+			got := make(map[int64]racing.Race_Status)
+			for _, race := range page.Races {
+				got[race.Id] = race.Status
+			}
+
+			assert.Equal(t, want, got)
+
+			for id, status := range want {
+				race, err := repos.races.Get(ctx, id, GetOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, status, race.Status, "race %d", id)
+			}
+		})
+	}
+}
+
+func TestConformancePageTokens(t *testing.T) {
+	visible := true
+
+	for _, impl := range repoImplementations {
+		t.Run(impl.name, func(t *testing.T) {
+			ctx := context.Background()
+			repos := loadConformanceRaces(t, impl.open)
+
+			filter := &racing.ListRacesRequestFilter{Visible: &visible}
+
+			first, err := repos.races.List(ctx, filter, ListOptions{OrderBy: "name", PageSize: 2})
+			require.NoError(t, err)
+			require.Equal(t, []int64{1, 2}, raceIDs(first.Races))
+			require.NotEmpty(t, first.NextPageToken)
+
+			for name, tc := range map[string]struct {
+				filter *racing.ListRacesRequestFilter
+				opts   ListOptions
+			}{
+				"changed filter":   {&racing.ListRacesRequestFilter{}, ListOptions{OrderBy: "name"}},
+				"changed order_by": {filter, ListOptions{OrderBy: "name desc"}},
+				"default order_by": {filter, ListOptions{}},
+			} {
+				tc.opts.PageToken = first.NextPageToken
+
+				_, err := repos.races.List(ctx, tc.filter, tc.opts)
+				assert.ErrorIs(t, err, ErrInvalidPageToken, name)
+			}
+
+			_, err = repos.races.List(ctx, filter, ListOptions{OrderBy: "name", PageToken: "garbage"})
+			assert.ErrorIs(t, err, ErrInvalidPageToken)
+
+			// Races added before the cursor do not shift the following pages,
+			// and races added after it appear in them.
+			_, err = repos.races.Create(ctx, []*racing.Race{
+				{MeetingId: 1, Name: "Aardvark", Number: 4, Visible: true, AdvertisedStartTime: timestamppb.New(conformanceNow)},
+				{MeetingId: 1, Name: "zulu", Number: 5, Visible: true, AdvertisedStartTime: timestamppb.New(conformanceNow)},
+			})
+			require.NoError(t, err)
+
+			second, err := repos.races.List(ctx, filter, ListOptions{OrderBy: "name", PageSize: 10, PageToken: first.NextPageToken})
+			require.NoError(t, err)
+
+			assert.Equal(t, []int64{6, 7, 8, 10, 4}, raceIDs(second.Races))
+			assert.EqualValues(t, 8, second.TotalSize)
+		})
+	}
+}
+
+func TestConformanceListOptions(t *testing.T) {
+	for _, impl := range repoImplementations {
+		t.Run(impl.name, func(t *testing.T) {
+			ctx := context.Background()
+			repos := loadConformanceRaces(t, impl.open)
+
+			_, err := repos.races.List(ctx, nil, ListOptions{OrderBy: "status"})
+			assert.ErrorIs(t, err, ErrInvalidOrderBy)
+
+			_, err = repos.races.List(ctx, nil, ListOptions{OrderBy: "name, name"})
+			assert.ErrorIs(t, err, ErrInvalidOrderBy)
+
+			_, err = repos.races.List(ctx, nil, ListOptions{PageSize: -1})
+			assert.ErrorIs(t, err, ErrInvalidPageSize)
+
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+
+			_, err = repos.races.List(cancelled, nil, ListOptions{})
+			assert.ErrorIs(t, err, context.Canceled)
+
+			_, err = repos.races.Get(cancelled, 1, GetOptions{})
+			assert.ErrorIs(t, err, context.Canceled)
+		})
+	}
+}
+
+func TestConformanceRunnersAndResults(t *testing.T) {
+	runners := []*racing.Runner{
+		{Barrier: 3, SaddleClothNumber: 2, Name: "Second", Jockey: "J Two", Trainer: "T Two", Weight: 55.5},
+		{Barrier: 1, SaddleClothNumber: 1, Name: "First", Jockey: "J One", Trainer: "T One", Weight: 57},
+		{Barrier: 2, SaddleClothNumber: 3, Name: "Third", Jockey: "J Three", Trainer: "T Three", Weight: 54, Scratched: true},
+	}
+
+	for _, impl := range repoImplementations {
+		t.Run(impl.name, func(t *testing.T) {
+			ctx := context.Background()
+			repos := loadConformanceRaces(t, impl.open)
+
+			repos.putRunners(t, 2, runners)
+			repos.putResult(t, &racing.RaceResult{
+				RaceId:   2,
+				Official: true,
+				Placings: []*racing.Placing{
+					{SaddleClothNumber: 1, Position: 2, Margin: 1.5},
+					{SaddleClothNumber: 2, Position: 1},
+				},
+				Dividends: []*racing.Dividend{
+					{BetType: racing.Dividend_PLACE, Selections: []int64{2}, Amount: 1.4},
+					{BetType: racing.Dividend_WIN, Selections: []int64{2}, Amount: 3.2},
+					{BetType: racing.Dividend_EXACTA, Selections: []int64{2, 1}, Amount: 12},
+				},
+			})
+
+			listed, err := repos.races.ListRunners(ctx, 2)
+			require.NoError(t, err)
+
+			var names []string
+			for _, runner := range listed {
+				assert.EqualValues(t, 2, runner.RaceId)
+				assert.NotZero(t, runner.Id)
+				names = append(names, runner.Name)
+			}
+
+			assert.Equal(t, []string{"First", "Second", "Third"}, names)
+			assert.True(t, listed[2].Scratched)
+			assert.Equal(t, 55.5, listed[1].Weight)
+
+			empty, err := repos.races.ListRunners(ctx, 3)
+			require.NoError(t, err)
+			assert.Empty(t, empty)
+
+			_, err = repos.races.ListRunners(ctx, 99)
+			assert.ErrorIs(t, err, ErrRaceNotFound)
+
+			race, err := repos.races.Get(ctx, 2, GetOptions{View: racing.RaceView_RACE_VIEW_FULL})
+			require.NoError(t, err)
+			assert.Len(t, race.Runners, 3)
+			assert.Equal(t, racing.Race_FINAL, race.Status)
+
+			basic, err := repos.races.Get(ctx, 2, GetOptions{})
+			require.NoError(t, err)
+			assert.Empty(t, basic.Runners)
+
+			page, err := repos.races.List(ctx, &racing.ListRacesRequestFilter{MeetingIds: []int64{1}}, ListOptions{View: racing.RaceView_RACE_VIEW_FULL})
+			require.NoError(t, err)
+
+			for _, race := range page.Races {
+				if race.Id == 2 {
+					assert.Len(t, race.Runners, 3)
+				} else {
+					assert.Empty(t, race.Runners, "race %d", race.Id)
+				}
+			}
+
+			result, err := repos.races.GetResult(ctx, 2)
+			require.NoError(t, err)
+
+			assert.True(t, result.Official)
+			require.Len(t, result.Placings, 2)
+			assert.EqualValues(t, 2, result.Placings[0].SaddleClothNumber)
+			assert.EqualValues(t, 1, result.Placings[1].SaddleClothNumber)
+			assert.Equal(t, 1.5, result.Placings[1].Margin)
+			assert.Equal(t, listed[1].Id, result.Placings[0].RunnerId)
+
+			var betTypes []racing.Dividend_BetType
+			for _, dividend := range result.Dividends {
+				betTypes = append(betTypes, dividend.BetType)
+			}
+
+			assert.Equal(t, []racing.Dividend_BetType{racing.Dividend_WIN, racing.Dividend_PLACE, racing.Dividend_EXACTA}, betTypes)
+			assert.Equal(t, []int64{2, 1}, result.Dividends[2].Selections)
+
+			_, err = repos.races.GetResult(ctx, 3)
+			assert.ErrorIs(t, err, ErrResultNotFound)
+
+			_, err = repos.races.GetResult(ctx, 99)
+			assert.ErrorIs(t, err, ErrRaceNotFound)
+		})
+	}
+}
+
+func TestConformanceMutations(t *testing.T) {
+	for _, impl := range repoImplementations {
+		t.Run(impl.name, func(t *testing.T) {
+			ctx := context.Background()
+			repos := loadConformanceRaces(t, impl.open)
+
+			created, err := repos.races.Create(ctx, []*racing.Race{
+				{MeetingId: 2, Name: "Created", Number: 4, Visible: true, AdvertisedStartTime: timestamppb.New(conformanceNow.Add(time.Minute))},
+			})
+			require.NoError(t, err)
+			require.Len(t, created, 1)
+
+			race := created[0]
+			assert.EqualValues(t, 9, race.Id)
+			assert.Equal(t, racing.Race_OPEN, race.Status)
+			assert.True(t, race.AdvertisedStartTime.AsTime().Equal(conformanceNow.Add(time.Minute)))
+
+			race.Name = "Updated"
+			race.Visible = false
+			race.AdvertisedStartTime = timestamppb.New(conformanceNow.Add(-time.Minute))
+
+			// Fields which aren't named are left as they are.
+			race.Number = 9
+
+			updated, err := repos.races.Update(ctx, race, []string{"name", "visible", "advertised_start_time"})
+			require.NoError(t, err)
+
+			assert.Equal(t, "Updated", updated.Name)
+			assert.False(t, updated.Visible)
+			assert.EqualValues(t, 4, updated.Number)
+			assert.Equal(t, racing.Race_CLOSED, updated.Status)
+
+			got, err := repos.races.Get(ctx, race.Id, GetOptions{})
+			require.NoError(t, err)
+			assert.True(t, proto.Equal(updated, got), "got %v, want %v", got, updated)
+
+			unchanged, err := repos.races.Update(ctx, &racing.Race{Id: race.Id}, nil)
+			require.NoError(t, err)
+			assert.True(t, proto.Equal(updated, unchanged), "got %v, want %v", unchanged, updated)
+
+			_, err = repos.races.Update(ctx, race, []string{"status"})
+			assert.ErrorContains(t, err, "not writable")
+
+			_, err = repos.races.Update(ctx, &racing.Race{Id: 99, Name: "Missing"}, []string{"name"})
+			assert.ErrorIs(t, err, ErrRaceNotFound)
+
+			_, err = repos.races.Update(ctx, &racing.Race{Id: 99}, nil)
+			assert.ErrorIs(t, err, ErrRaceNotFound)
+
+			// Deleting a race deletes its runners and result.
+			repos.putRunners(t, 1, []*racing.Runner{{SaddleClothNumber: 1, Name: "Winner"}})
+
+			require.NoError(t, repos.races.Delete(ctx, 1))
+			assert.ErrorIs(t, repos.races.Delete(ctx, 1), ErrRaceNotFound)
+
+			_, err = repos.races.Get(ctx, 1, GetOptions{})
+			assert.ErrorIs(t, err, ErrRaceNotFound)
+
+			_, err = repos.races.ListRunners(ctx, 1)
+			assert.ErrorIs(t, err, ErrRaceNotFound)
+
+			_, err = repos.races.GetResult(ctx, 1)
+			assert.ErrorIs(t, err, ErrRaceNotFound)
+
+			page, err := repos.races.List(ctx, &racing.ListRacesRequestFilter{Status: racing.Race_FINAL}, ListOptions{})
+			require.NoError(t, err)
+			assert.Empty(t, page.Races)
+
+			page, err = repos.races.List(ctx, nil, ListOptions{})
+			require.NoError(t, err)
+			assert.EqualValues(t, 8, page.TotalSize)
+		})
+	}
+}
+
+func TestConformanceMeetings(t *testing.T) {
+	for _, impl := range repoImplementations {
+		t.Run(impl.name, func(t *testing.T) {
+			ctx := context.Background()
+			repos := impl.open(t, conformanceMeetings)
+
+			meetings, err := repos.meetings.List(ctx, nil)
+			require.NoError(t, err)
+
+			// Meetings are ordered by date, then venue.
+			var ids []int64
+			for _, meeting := range meetings {
+				ids = append(ids, meeting.Id)
+			}
+
+			assert.Equal(t, []int64{3, 1, 2}, ids)
+			assert.True(t, proto.Equal(conformanceMeetings[2], meetings[0]))
+
+			meetings, err = repos.meetings.List(ctx, &racing.ListMeetingsRequestFilter{
+				RaceTypes: []racing.Meeting_RaceType{racing.Meeting_THOROUGHBRED, racing.Meeting_HARNESS},
+				Venues:    []string{"Menangle", "Sandown Park"},
+			})
+			require.NoError(t, err)
+			require.Len(t, meetings, 1)
+			assert.EqualValues(t, 2, meetings[0].Id)
+
+			meeting, err := repos.meetings.Get(ctx, 1)
+			require.NoError(t, err)
+			assert.True(t, proto.Equal(conformanceMeetings[0], meeting))
+
+			_, err = repos.meetings.Get(ctx, 4)
+			assert.ErrorIs(t, err, ErrMeetingNotFound)
+		})
+	}
+}
@@ -1,30 +1,290 @@
 package db
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math/rand"
 	"time"
 
+	"git.neds.sh/matty/entain/racing/proto/racing"
 	"syreclabs.com/go/faker"
 )
 
+// RepoOptions controls how the SQL repositories are initialised.
+type RepoOptions struct {
+	// Seed fills the database with dummy data, for test/example purposes.
+	Seed bool
+}
+
+// venues are the venues dummy meetings are held at, indexed by meeting ID.
+var venues = []struct {
+	name, state, country string
+	raceType             racing.Meeting_RaceType
+}{
+	{"Flemington", "VIC", "AUS", racing.Meeting_THOROUGHBRED},
+	{"Randwick", "NSW", "AUS", racing.Meeting_THOROUGHBRED},
+	{"Eagle Farm", "QLD", "AUS", racing.Meeting_THOROUGHBRED},
+	{"Morphettville", "SA", "AUS", racing.Meeting_THOROUGHBRED},
+	{"Menangle", "NSW", "AUS", racing.Meeting_HARNESS},
+	{"Melton", "VIC", "AUS", racing.Meeting_HARNESS},
+	{"Addington", "CAN", "NZL", racing.Meeting_HARNESS},
+	{"Wentworth Park", "NSW", "AUS", racing.Meeting_GREYHOUND},
+	{"Sandown Park", "VIC", "AUS", racing.Meeting_GREYHOUND},
+	{"Albion Park", "QLD", "AUS", racing.Meeting_GREYHOUND},
+}
+
+// trackConditions are the track ratings dummy meetings may be held on.
+var trackConditions = []string{"Firm 1", "Good 3", "Good 4", "Soft 5", "Soft 7", "Heavy 8", "Heavy 10"}
+
+func (r *meetingsRepo) seed() error {
+	statement, err := r.db.Prepare(r.db.Dialect().InsertIgnore(`meetings(id, venue, state, country, race_type, track_condition, date) VALUES (?,?,?,?,?,?,?)`))
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	for i, venue := range venues {
+		_, err = statement.Exec(
+			i+1,
+			venue.name,
+			venue.state,
+			venue.country,
+			int32(venue.raceType),
+			faker.RandomChoice(trackConditions),
+			time.Now().AddDate(0, 0, faker.RandomInt(-1, 2)).Format("2006-01-02"),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return syncSequence(r.db, "meetings")
+}
+
 func (r *racesRepo) seed() error {
-	statement, err := r.db.Prepare(`CREATE TABLE IF NOT EXISTS races (id INTEGER PRIMARY KEY, meeting_id INTEGER, name TEXT, number INTEGER, visible INTEGER, advertised_start_time DATETIME)`)
-	if err == nil {
-		_, err = statement.Exec()
+	statement, err := r.db.Prepare(r.db.Dialect().InsertIgnore(`races(id, meeting_id, name, number, visible, advertised_start_time) VALUES (?,?,?,?,?,?)`))
+	if err != nil {
+		return err
 	}
+	defer statement.Close()
+
+	runners, err := r.db.Prepare(r.db.Dialect().InsertIgnore(`runners(race_id, barrier, saddle_cloth_number, name, jockey, trainer, weight, scratched) VALUES (?,?,?,?,?,?,?,?)`))
+	if err != nil {
+		return err
+	}
+	defer runners.Close()
 
 	for i := 1; i <= 100; i++ {
-		statement, err = r.db.Prepare(`INSERT OR IGNORE INTO races(id, meeting_id, name, number, visible, advertised_start_time) VALUES (?,?,?,?,?,?)`)
-		if err == nil {
-			_, err = statement.Exec(
-				i,
-				faker.Number().Between(1, 10),
-				faker.Team().Name(),
-				faker.Number().Between(1, 12),
-				faker.Number().Between(0, 1),
-				faker.Time().Between(time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 2)).Format(time.RFC3339),
-			)
+		_, err = statement.Exec(
+			i,
+			faker.Number().Between(1, 10),
+			faker.Team().Name(),
+			faker.Number().Between(1, 12),
+			faker.Number().Between(0, 1),
+			faker.Time().Between(time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 2)).Format(time.RFC3339),
+		)
+		if err != nil {
+			return err
+		}
+
+		if err := r.seedRunners(runners, int64(i)); err != nil {
+			return err
 		}
 	}
 
+	if err := syncSequence(r.db, "races"); err != nil {
+		return err
+	}
+
+	return r.seedResults()
+}
+
+// syncSequence moves a table's ID sequence past the seeded IDs, so that rows
+// inserted later are not assigned IDs already taken.
+func syncSequence(db *DB, table string) error {
+	query := db.Dialect().SyncSequence(table)
+	if query == "" {
+		return nil
+	}
+
+	_, err := db.Exec(query)
+
 	return err
 }
+
+// seedRunners seeds a field of dummy runners for the given race with the
+// prepared runners insert. The field size is derived from the race ID so that
+// re-seeding is idempotent.
+func (r *racesRepo) seedRunners(statement *sql.Stmt, raceID int64) error {
+	fieldSize := 6 + int(raceID%7)
+	barriers := rand.Perm(fieldSize)
+
+	for i := 1; i <= fieldSize; i++ {
+		_, err := statement.Exec(
+			raceID,
+			barriers[i-1]+1,
+			i,
+			faker.App().Name(),
+			faker.Name().Name(),
+			faker.Name().Name(),
+			float64(faker.RandomInt(100, 124))/2,
+			faker.RandomInt(1, 10) == 1,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resultDelay is how long after its advertised start a dummy race is resulted,
+// leaving recently started races closed.
+const resultDelay = 15 * time.Minute
+
+// seedResults seeds dummy results for races which started over resultDelay
+// ago and have no result yet.
+func (r *racesRepo) seedResults() error {
+	dialect := r.db.Dialect()
+
+	rows, err := r.db.Query(
+		`SELECT races.id FROM races LEFT JOIN race_results ON race_results.race_id = races.id WHERE race_results.race_id IS NULL AND `+
+			dialect.Datetime("races.advertised_start_time")+` <= `+dialect.Datetime("?"),
+		time.Now().Add(-resultDelay).Format(time.RFC3339),
+	)
+	if err != nil {
+		return err
+	}
+
+	var raceIDs []int64
+
+	for rows.Next() {
+		var raceID int64
+		if err := rows.Scan(&raceID); err != nil {
+			rows.Close()
+			return err
+		}
+
+		raceIDs = append(raceIDs, raceID)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, raceID := range raceIDs {
+		if err := r.seedResult(raceID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// seedResult seeds a dummy result for the given race. Most results are final,
+// with the remainder interim, under protest or abandoned.
+func (r *racesRepo) seedResult(raceID int64) error {
+	var official, protest, abandoned bool
+
+	switch faker.RandomInt(1, 10) {
+	case 1:
+		official, abandoned = true, true
+	case 2:
+	case 3:
+		protest = true
+	default:
+		official = true
+	}
+
+	runners, err := r.listRunners(context.Background(), []int64{raceID})
+	if err != nil {
+		return err
+	}
+
+	// The result is written in a transaction, so that it's never left without
+	// its placings and dividends.
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(r.db.Dialect().InsertIgnore(`race_results(race_id, official, protest, abandoned) VALUES (?,?,?,?)`), raceID, official, protest, abandoned)
+	if err != nil {
+		return err
+	}
+
+	if abandoned {
+		return tx.Commit()
+	}
+
+	var finishers []*racing.Runner
+	for _, runner := range runners[raceID] {
+		if !runner.Scratched {
+			finishers = append(finishers, runner)
+		}
+	}
+
+	rand.Shuffle(len(finishers), func(i, j int) {
+		finishers[i], finishers[j] = finishers[j], finishers[i]
+	})
+
+	order := make([]int64, 0, len(finishers))
+
+	for i, runner := range finishers {
+		margin := 0.0
+		if i > 0 {
+			margin = float64(faker.RandomInt(1, 40)) / 4
+		}
+
+		_, err = tx.Exec(r.db.Dialect().InsertIgnore(`result_placings(race_id, runner_id, position, margin) VALUES (?,?,?,?)`), raceID, runner.Id, i+1, margin)
+		if err != nil {
+			return err
+		}
+
+		order = append(order, runner.SaddleClothNumber)
+	}
+
+	// Each dividend is paid on the finishers from position from+1 to to.
+	dividends := []struct {
+		betType  racing.Dividend_BetType
+		from, to int
+		min, max int
+	}{
+		{racing.Dividend_WIN, 0, 1, 1, 30},
+		{racing.Dividend_PLACE, 0, 1, 1, 8},
+		{racing.Dividend_PLACE, 1, 2, 1, 8},
+		{racing.Dividend_PLACE, 2, 3, 1, 8},
+		{racing.Dividend_QUINELLA, 0, 2, 3, 150},
+		{racing.Dividend_EXACTA, 0, 2, 5, 300},
+		{racing.Dividend_TRIFECTA, 0, 3, 20, 2000},
+		{racing.Dividend_FIRST_FOUR, 0, 4, 50, 10000},
+	}
+
+	for _, dividend := range dividends {
+		if dividend.to > len(order) {
+			continue
+		}
+
+		selections, err := json.Marshal(order[dividend.from:dividend.to])
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			r.db.Dialect().InsertIgnore(`result_dividends(race_id, bet_type, selections, amount) VALUES (?,?,?,?)`),
+			raceID,
+			int32(dividend.betType),
+			string(selections),
+			float64(faker.RandomInt(dividend.min*100+1, dividend.max*100))/100,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
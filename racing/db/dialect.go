@@ -0,0 +1,249 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Dialect abstracts the differences between the SQL databases races may be
+// stored in. Queries are written with ? placeholders and SQLite-compatible
+// syntax, and adapted by the dialect where the databases differ.
+type Dialect interface {
+	// Name identifies the dialect, and names its migrations directory.
+	Name() string
+
+	// Rebind rewrites the ? placeholders in a query into the dialect's style.
+	Rebind(query string) string
+
+	// Datetime wraps a timestamp expression, or placeholder, so that it
+	// compares chronologically.
+	Datetime(expr string) string
+
+	// Bytewise wraps a text expression so that it compares byte by byte, as
+	// Go compares strings, whatever the database's collation.
+	Bytewise(expr string) string
+
+	// InsertIgnore returns an insert which silently skips rows conflicting
+	// with an existing key, where into is e.g. "races(id, name) VALUES (?,?)".
+	InsertIgnore(into string) string
+
+	// TableExists returns a query, taking the table name, which selects
+	// whether a table exists.
+	TableExists() string
+
+	// SyncSequence returns a statement which moves the ID sequence of a table
+	// past rows inserted with explicit IDs, or "" if none is needed.
+	SyncSequence(table string) string
+
+	// Returning reports whether inserts return their IDs via a RETURNING
+	// clause, rather than sql.Result.LastInsertId.
+	Returning() bool
+}
+
+// Open connects to the database named by dsn. DSNs starting "postgres://" or
+// "postgresql://" select PostgreSQL; anything else is a SQLite file path,
+// optionally prefixed with "sqlite3://".
+func Open(dsn string) (*DB, error) {
+	var (
+		driver  string
+		dialect Dialect
+	)
+
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		driver, dialect = "postgres", postgresDialect{}
+	default:
+		driver, dialect = "sqlite3", sqliteDialect{}
+		dsn = strings.TrimPrefix(dsn, "sqlite3://")
+	}
+
+	conn, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DB{DB: conn, dialect: dialect}, nil
+}
+
+// sqliteDialect adapts queries for SQLite, in which timestamps are stored as
+// text.
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string { return "sqlite" }
+
+func (sqliteDialect) Rebind(query string) string { return query }
+
+func (sqliteDialect) Datetime(expr string) string { return "datetime(" + expr + ")" }
+
+// Bytewise returns expr as is, as SQLite's default collation is BINARY.
+func (sqliteDialect) Bytewise(expr string) string { return expr }
+
+func (sqliteDialect) InsertIgnore(into string) string { return "INSERT OR IGNORE INTO " + into }
+
+func (sqliteDialect) TableExists() string {
+	return `SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)`
+}
+
+func (sqliteDialect) SyncSequence(string) string { return "" }
+
+func (sqliteDialect) Returning() bool { return false }
+
+// postgresDialect adapts queries for PostgreSQL, in which timestamps are
+// stored natively.
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return "postgres" }
+
+// Rebind numbers placeholders $1, $2, ..., skipping any ? within quotes.
+func (postgresDialect) Rebind(query string) string {
+	var (
+		b     strings.Builder
+		n     int
+		quote rune
+	)
+
+	for _, c := range query {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+func (postgresDialect) Datetime(expr string) string { return expr }
+
+// Bytewise applies the "C" collation, which compares UTF-8 text byte by byte,
+// where the database's default collation may follow the locale.
+func (postgresDialect) Bytewise(expr string) string { return expr + ` COLLATE "C"` }
+
+func (postgresDialect) InsertIgnore(into string) string {
+	return "INSERT INTO " + into + " ON CONFLICT DO NOTHING"
+}
+
+func (postgresDialect) TableExists() string {
+	return `SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?)`
+}
+
+func (postgresDialect) SyncSequence(table string) string {
+	return fmt.Sprintf(
+		`SELECT setval(pg_get_serial_sequence('%[1]s', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM %[1]s`,
+		table,
+	)
+}
+
+func (postgresDialect) Returning() bool { return true }
+
+// DB wraps a database connection with its dialect, rebinding the
+// placeholders of every query it runs.
+type DB struct {
+	*sql.DB
+	dialect Dialect
+}
+
+// Dialect returns the dialect of the database.
+func (db *DB) Dialect() Dialect {
+	return db.dialect
+}
+
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.Rebind(query), args...)
+}
+
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.Rebind(query), args...)
+}
+
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.dialect.Rebind(query), args...)
+}
+
+func (db *DB) Prepare(query string) (*sql.Stmt, error) {
+	return db.DB.Prepare(db.dialect.Rebind(query))
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.ExecContext(ctx, db.dialect.Rebind(query), args...)
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.QueryContext(ctx, db.dialect.Rebind(query), args...)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRowContext(ctx, db.dialect.Rebind(query), args...)
+}
+
+func (db *DB) Begin() (*Tx, error) {
+	return db.BeginTx(context.Background(), nil)
+}
+
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{Tx: tx, dialect: db.dialect}, nil
+}
+
+// Tx wraps a transaction with its dialect, as DB does a connection.
+type Tx struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) Prepare(query string) (*sql.Stmt, error) {
+	return tx.Tx.Prepare(tx.dialect.Rebind(query))
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.ExecContext(ctx, tx.dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRowContext(ctx, tx.dialect.Rebind(query), args...)
+}
+
+// Insert runs an insert into a table with an id column, returning the ID of
+// the inserted row.
+func (tx *Tx) Insert(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	if tx.dialect.Returning() {
+		var id int64
+		err := tx.QueryRowContext(ctx, query+" RETURNING id", args...).Scan(&id)
+
+		return id, err
+	}
+
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
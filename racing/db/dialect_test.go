@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/clock"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestOpen(t *testing.T) {
+	for dsn, dialect := range map[string]string{
+		"racing.db":                         "sqlite",
+		"sqlite3://racing.db":               "sqlite",
+		"file:racing.db?cache=shared":       "sqlite",
+		"postgres://localhost/racing":       "postgres",
+		"postgresql://u:p@localhost/racing": "postgres",
+	} {
+		db, err := Open(dsn)
+		require.NoError(t, err, dsn)
+
+		assert.Equal(t, dialect, db.Dialect().Name(), dsn)
+		db.Close()
+	}
+}
+
+func TestPostgresRebind(t *testing.T) {
+	for query, want := range map[string]string{
+		`SELECT 1`:                                   `SELECT 1`,
+		`SELECT * FROM races WHERE id = ?`:           `SELECT * FROM races WHERE id = $1`,
+		`INSERT INTO t(a, b, c) VALUES (?,?,?)`:      `INSERT INTO t(a, b, c) VALUES ($1,$2,$3)`,
+		`SELECT '?' FROM t WHERE a = ? AND b = '?'`:  `SELECT '?' FROM t WHERE a = $1 AND b = '?'`,
+		`SELECT "a?" FROM t WHERE a IN (?,?)`:        `SELECT "a?" FROM t WHERE a IN ($1,$2)`,
+		`SELECT 'it''s?' FROM t WHERE a = ?`:         `SELECT 'it''s?' FROM t WHERE a = $1`,
+		`SELECT ? || 'é' || ? FROM t WHERE name = ?`: `SELECT $1 || 'é' || $2 FROM t WHERE name = $3`,
+	} {
+		assert.Equal(t, want, postgresDialect{}.Rebind(query), query)
+	}
+
+	assert.Equal(t, `SELECT ? FROM t`, sqliteDialect{}.Rebind(`SELECT ? FROM t`))
+}
+
+// TestSeed checks that seeding is repeatable, and that races created after
+// seeding are assigned IDs beyond the seeded ones, which on PostgreSQL relies
+// on the sequences being synced.
+func TestSeed(t *testing.T) {
+	ctx := context.Background()
+	db := openEmptyTestDB(t)
+
+	meetings := NewMeetingsRepo(db, RepoOptions{Seed: true})
+	require.NoError(t, meetings.Init())
+
+	races := NewRacesRepo(db, clock.New(), RepoOptions{Seed: true})
+	require.NoError(t, races.Init())
+
+	// A second process seeding the same database skips the existing rows.
+	require.NoError(t, NewRacesRepo(db, clock.New(), RepoOptions{Seed: true}).Init())
+
+	page, err := races.List(ctx, nil, ListOptions{PageSize: MaxPageSize})
+	require.NoError(t, err)
+	require.EqualValues(t, 100, page.TotalSize)
+
+	listed, err := meetings.List(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, listed, len(venues))
+
+	created, err := races.Create(ctx, []*racing.Race{{
+		MeetingId:           1,
+		Name:                "Created",
+		Number:              1,
+		AdvertisedStartTime: timestamppb.New(time.Now().Truncate(time.Second)),
+	}})
+	require.NoError(t, err)
+	assert.EqualValues(t, 101, created[0].Id)
+
+	runners, err := races.ListRunners(ctx, 1)
+	require.NoError(t, err)
+	assert.Len(t, runners, 6+1%7)
+}
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+
+	"git.neds.sh/matty/entain/racing/proto/racing"
+)
+
+// ErrMeetingNotFound is returned when a requested meeting does not exist.
+var ErrMeetingNotFound = errors.New("meeting not found")
+
+// MeetingsRepo provides repository access to meetings.
+type MeetingsRepo interface {
+	// Init will initialise our meetings repository.
+	Init() error
+
+	// List will return a list of meetings, ordered by date then venue.
+	List(ctx context.Context, filter *racing.ListMeetingsRequestFilter) ([]*racing.Meeting, error)
+
+	// Get will return a single meeting by its ID, or ErrMeetingNotFound.
+	Get(ctx context.Context, id int64) (*racing.Meeting, error)
+}
+
+type meetingsRepo struct {
+	db   *DB
+	opts RepoOptions
+	init sync.Once
+}
+
+// NewMeetingsRepo creates a new meetings repository.
+func NewMeetingsRepo(db *DB, opts RepoOptions) MeetingsRepo {
+	return &meetingsRepo{db: db, opts: opts}
+}
+
+// Init prepares the meetings repository dummy data.
+func (r *meetingsRepo) Init() error {
+	var err error
+
+	r.init.Do(func() {
+		if err = migrateUp(r.db); err != nil {
+			return
+		}
+
+		// For test/example purposes, we seed the DB with some dummy meetings.
+		if r.opts.Seed {
+			err = r.seed()
+		}
+	})
+
+	return err
+}
+
+func (r *meetingsRepo) List(ctx context.Context, filter *racing.ListMeetingsRequestFilter) ([]*racing.Meeting, error) {
+	var (
+		clauses []string
+		args    []interface{}
+	)
+
+	if filter == nil {
+		filter = &racing.ListMeetingsRequestFilter{}
+	}
+
+	clauses, args = meetingClauses(filter.RaceTypes, filter.Venues, clauses, args)
+
+	query := getRaceQueries()[meetingsList]
+	if len(clauses) != 0 {
+		query += " WHERE " + strings.Join(clauses, " AND ")
+	}
+
+	query += " ORDER BY date, venue, id"
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return r.scanMeetings(rows)
+}
+
+func (r *meetingsRepo) Get(ctx context.Context, id int64) (*racing.Meeting, error) {
+	query := getRaceQueries()[meetingsList] + " WHERE id = ?"
+
+	rows, err := r.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	meetings, err := r.scanMeetings(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(meetings) == 0 {
+		return nil, ErrMeetingNotFound
+	}
+
+	return meetings[0], nil
+}
+
+// meetingClauses appends the conditions selecting meetings of the given race
+// types and venues. It is shared by the races filter, which joins meetings.
+func meetingClauses(
+	raceTypes []racing.Meeting_RaceType,
+	venues []string,
+	clauses []string,
+	args []interface{},
+) ([]string, []interface{}) {
+	if len(raceTypes) > 0 {
+		clauses = append(clauses, "meetings.race_type IN ("+strings.Repeat("?,", len(raceTypes)-1)+"?)")
+
+		for _, raceType := range raceTypes {
+			args = append(args, int32(raceType))
+		}
+	}
+
+	if len(venues) > 0 {
+		clauses = append(clauses, "meetings.venue IN ("+strings.Repeat("?,", len(venues)-1)+"?)")
+
+		for _, venue := range venues {
+			args = append(args, venue)
+		}
+	}
+
+	return clauses, args
+}
+
+func (r *meetingsRepo) scanMeetings(rows *sql.Rows) ([]*racing.Meeting, error) {
+	var meetings []*racing.Meeting
+
+	for rows.Next() {
+		var meeting racing.Meeting
+
+		if err := rows.Scan(
+			&meeting.Id,
+			&meeting.Venue,
+			&meeting.State,
+			&meeting.Country,
+			&meeting.RaceType,
+			&meeting.TrackCondition,
+			&meeting.Date,
+		); err != nil {
+			return nil, err
+		}
+
+		meetings = append(meetings, &meeting)
+	}
+
+	return meetings, rows.Err()
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestMeetingsJoin checks races are filtered by the race type and venue of
+// the meeting they are joined to, and that meetings are read in full.
+func TestMeetingsJoin(t *testing.T) {
+	ctx := context.Background()
+
+	meetings := []*racing.Meeting{
+		{Id: 1, Venue: "Flemington", State: "VIC", Country: "AUS", RaceType: racing.Meeting_THOROUGHBRED, TrackCondition: "Good 4", Date: "2021-03-02"},
+		{Id: 2, Venue: "Menangle", State: "NSW", Country: "AUS", RaceType: racing.Meeting_HARNESS, TrackCondition: "Good 3", Date: "2021-03-02"},
+		{Id: 3, Venue: "Menangle", State: "NSW", Country: "AUS", RaceType: racing.Meeting_GREYHOUND, TrackCondition: "Soft 5", Date: "2021-03-03"},
+	}
+
+	repos := openSQLRepos(t, meetings)
+
+	// Race 4 is held at a meeting which does not exist.
+	var races []*racing.Race
+	for i, meetingID := range []int64{1, 2, 3, 4} {
+		races = append(races, &racing.Race{
+			MeetingId:           meetingID,
+			Name:                "Race",
+			Number:              1,
+			Visible:             true,
+			AdvertisedStartTime: timestamppb.New(conformanceNow.Add(time.Duration(i) * time.Hour)),
+		})
+	}
+
+	_, err := repos.races.Create(ctx, races)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name   string
+		filter *racing.ListRacesRequestFilter
+		want   []int64
+	}{
+		{
+			name: "no meeting filter",
+			want: []int64{1, 2, 3, 4},
+		},
+		{
+			name:   "race type",
+			filter: &racing.ListRacesRequestFilter{RaceTypes: []racing.Meeting_RaceType{racing.Meeting_HARNESS}},
+			want:   []int64{2},
+		},
+		{
+			name:   "venue held at several meetings",
+			filter: &racing.ListRacesRequestFilter{Venues: []string{"Menangle"}},
+			want:   []int64{2, 3},
+		},
+		{
+			name: "race type and venue",
+			filter: &racing.ListRacesRequestFilter{
+				RaceTypes: []racing.Meeting_RaceType{racing.Meeting_THOROUGHBRED, racing.Meeting_GREYHOUND},
+				Venues:    []string{"Menangle"},
+			},
+			want: []int64{3},
+		},
+		{
+			name:   "race type and meeting ids",
+			filter: &racing.ListRacesRequestFilter{MeetingIds: []int64{1, 2}, RaceTypes: []racing.Meeting_RaceType{racing.Meeting_THOROUGHBRED}},
+			want:   []int64{1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			page, err := repos.races.List(ctx, tc.filter, ListOptions{})
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, raceIDs(page.Races))
+			assert.EqualValues(t, len(tc.want), page.TotalSize)
+		})
+	}
+
+	listed, err := repos.meetings.List(ctx, &racing.ListMeetingsRequestFilter{Venues: []string{"Menangle"}})
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+
+	for i, meeting := range listed {
+		assert.True(t, proto.Equal(meetings[i+1], meeting), "got %v", meeting)
+	}
+
+	meeting, err := repos.meetings.Get(ctx, 1)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(meetings[0], meeting), "got %v", meeting)
+}
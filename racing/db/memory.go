@@ -0,0 +1,566 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/clock"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// MemoryRacesRepo is a RacesRepo held in memory, for tests and demos which
+// shouldn't need a database. It filters, orders and pages races exactly as the
+// SQL repository does, and is safe for concurrent use. Like the SQL repository,
+// it returns the context's error if called with a done context.
+type MemoryRacesRepo struct {
+	meetingsRepo MeetingsRepo
+	clock        clock.Clock
+
+	mu      sync.RWMutex
+	races   map[int64]*racing.Race
+	runners map[int64][]*racing.Runner
+	results map[int64]*racing.RaceResult
+	nextID  int64
+}
+
+var _ RacesRepo = (*MemoryRacesRepo)(nil)
+
+// NewMemoryRacesRepo creates an empty in-memory races repository. Races are
+// filtered by race type and venue through the meetings repository.
+func NewMemoryRacesRepo(meetingsRepo MeetingsRepo, clock clock.Clock) *MemoryRacesRepo {
+	return &MemoryRacesRepo{
+		meetingsRepo: meetingsRepo,
+		clock:        clock,
+		races:        make(map[int64]*racing.Race),
+		runners:      make(map[int64][]*racing.Runner),
+		results:      make(map[int64]*racing.RaceResult),
+		nextID:       1,
+	}
+}
+
+// Init does nothing, as the repository starts empty.
+func (r *MemoryRacesRepo) Init() error {
+	return nil
+}
+
+func (r *MemoryRacesRepo) List(ctx context.Context, filter *racing.ListRacesRequestFilter, opts ListOptions) (*RacesPage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var page RacesPage
+
+	// Capture now once so that the status filter and the derived statuses agree.
+	now := r.clock.Now()
+
+	terms, err := parseOrderBy(opts.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := pageSize(opts.PageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := listFingerprint(filter, terms)
+	if err != nil {
+		return nil, err
+	}
+
+	var token *pageToken
+
+	if opts.PageToken != "" {
+		if token, err = parsePageToken(opts.PageToken, fingerprint, terms); err != nil {
+			return nil, err
+		}
+	}
+
+	match, err := r.matcher(ctx, filter, now)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var races []*racing.Race
+
+	for _, race := range r.races {
+		if !match(race) {
+			continue
+		}
+
+		page.TotalSize++
+
+		if token != nil {
+			cmp, err := compareRaceKey(terms, race, token.After)
+			if err != nil {
+				return nil, err
+			}
+
+			if cmp <= 0 {
+				continue
+			}
+		}
+
+		races = append(races, race)
+	}
+
+	sort.Slice(races, func(i, j int) bool {
+		cmp, _ := compareRaceKey(terms, races[i], orderKey(terms, races[j]))
+		return cmp < 0
+	})
+
+	if len(races) > size {
+		races = races[:size]
+
+		page.NextPageToken, err = newPageToken(fingerprint, terms, races[size-1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, race := range races {
+		page.Races = append(page.Races, r.race(race, now, opts.View))
+	}
+
+	return &page, nil
+}
+
+func (r *MemoryRacesRepo) Get(ctx context.Context, id int64, opts GetOptions) (*racing.Race, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	race, ok := r.races[id]
+	if !ok {
+		return nil, ErrRaceNotFound
+	}
+
+	return r.race(race, r.clock.Now(), opts.View), nil
+}
+
+func (r *MemoryRacesRepo) ListRunners(ctx context.Context, raceID int64) ([]*racing.Runner, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.races[raceID]; !ok {
+		return nil, ErrRaceNotFound
+	}
+
+	return cloneRunners(r.runners[raceID]), nil
+}
+
+func (r *MemoryRacesRepo) GetResult(ctx context.Context, raceID int64) (*racing.RaceResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.races[raceID]; !ok {
+		return nil, ErrRaceNotFound
+	}
+
+	result, ok := r.results[raceID]
+	if !ok {
+		return nil, ErrResultNotFound
+	}
+
+	return proto.Clone(result).(*racing.RaceResult), nil
+}
+
+func (r *MemoryRacesRepo) Create(ctx context.Context, races []*racing.Race) ([]*racing.Race, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	created := make([]*racing.Race, 0, len(races))
+
+	for _, race := range races {
+		stored := storedRace(race)
+		stored.Id = r.nextID
+		r.nextID++
+
+		r.races[stored.Id] = stored
+		created = append(created, r.race(stored, now, racing.RaceView_RACE_VIEW_BASIC))
+	}
+
+	return created, nil
+}
+
+func (r *MemoryRacesRepo) Update(ctx context.Context, race *racing.Race, fields []string) (*racing.Race, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.races[race.Id]
+	if !ok {
+		return nil, ErrRaceNotFound
+	}
+
+	updated := proto.Clone(existing).(*racing.Race)
+	descriptors := updated.ProtoReflect().Descriptor().Fields()
+
+	for _, field := range fields {
+		if _, ok := raceColumns[field]; !ok {
+			return nil, fmt.Errorf("race field %q is not writable", field)
+		}
+
+		descriptor := descriptors.ByName(protoreflect.Name(field))
+		if race.ProtoReflect().Has(descriptor) {
+			updated.ProtoReflect().Set(descriptor, race.ProtoReflect().Get(descriptor))
+		} else {
+			updated.ProtoReflect().Clear(descriptor)
+		}
+	}
+
+	stored := storedRace(updated)
+	r.races[stored.Id] = stored
+
+	return r.race(stored, r.clock.Now(), racing.RaceView_RACE_VIEW_BASIC), nil
+}
+
+func (r *MemoryRacesRepo) Delete(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.races[id]; !ok {
+		return ErrRaceNotFound
+	}
+
+	delete(r.races, id)
+	delete(r.runners, id)
+	delete(r.results, id)
+
+	return nil
+}
+
+// PutRunners replaces the runners of a race, or returns ErrRaceNotFound.
+// Runners are returned ordered by saddle cloth number, as they are from SQL.
+func (r *MemoryRacesRepo) PutRunners(raceID int64, runners []*racing.Runner) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.races[raceID]; !ok {
+		return ErrRaceNotFound
+	}
+
+	stored := cloneRunners(runners)
+	for _, runner := range stored {
+		runner.RaceId = raceID
+	}
+
+	sort.SliceStable(stored, func(i, j int) bool {
+		return stored[i].SaddleClothNumber < stored[j].SaddleClothNumber
+	})
+
+	r.runners[raceID] = stored
+
+	return nil
+}
+
+// PutResult records the result of a race, or returns ErrRaceNotFound.
+// Placings are returned ordered by position and dividends by bet type, as they
+// are from SQL.
+func (r *MemoryRacesRepo) PutResult(result *racing.RaceResult) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.races[result.RaceId]; !ok {
+		return ErrRaceNotFound
+	}
+
+	stored := proto.Clone(result).(*racing.RaceResult)
+
+	sort.SliceStable(stored.Placings, func(i, j int) bool {
+		return stored.Placings[i].Position < stored.Placings[j].Position
+	})
+
+	sort.SliceStable(stored.Dividends, func(i, j int) bool {
+		return stored.Dividends[i].BetType < stored.Dividends[j].BetType
+	})
+
+	r.results[result.RaceId] = stored
+
+	return nil
+}
+
+// matcher returns a predicate selecting the races matching the filter. It must
+// be called before taking the lock, as it may query the meetings repository.
+func (r *MemoryRacesRepo) matcher(
+	ctx context.Context,
+	filter *racing.ListRacesRequestFilter,
+	now time.Time,
+) (func(race *racing.Race) bool, error) {
+	if filter == nil {
+		filter = &racing.ListRacesRequestFilter{}
+	}
+
+	meetingIDs := make(map[int64]bool)
+	for _, id := range filter.MeetingIds {
+		meetingIDs[id] = true
+	}
+
+	// Races are joined to meetings only when the filter references them, so
+	// that otherwise races of unknown meetings are still listed.
+	var meetings map[int64]bool
+
+	if len(filter.RaceTypes) > 0 || len(filter.Venues) > 0 {
+		matching, err := r.meetingsRepo.List(ctx, &racing.ListMeetingsRequestFilter{
+			RaceTypes: filter.RaceTypes,
+			Venues:    filter.Venues,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		meetings = make(map[int64]bool, len(matching))
+		for _, meeting := range matching {
+			meetings[meeting.Id] = true
+		}
+	}
+
+	return func(race *racing.Race) bool {
+		switch {
+		case len(meetingIDs) > 0 && !meetingIDs[race.MeetingId]:
+			return false
+		case filter.Visible != nil && race.Visible != filter.GetVisible():
+			return false
+		case meetings != nil && !meetings[race.MeetingId]:
+			return false
+		case filter.Status != racing.Race_STATUS_UNSPECIFIED && r.status(race, now) != filter.Status:
+			return false
+		}
+
+		return true
+	}, nil
+}
+
+// race returns a copy of a stored race, with its derived status and, in the
+// full view, its runners. The lock must be held.
+func (r *MemoryRacesRepo) race(stored *racing.Race, now time.Time, view racing.RaceView) *racing.Race {
+	race := proto.Clone(stored).(*racing.Race)
+	race.Status = r.status(stored, now)
+
+	if view == racing.RaceView_RACE_VIEW_FULL {
+		race.Runners = cloneRunners(r.runners[race.Id])
+	}
+
+	return race
+}
+
+// status derives a race's status as the SQL repository does. The lock must be
+// held.
+func (r *MemoryRacesRepo) status(race *racing.Race, now time.Time) racing.Race_Status {
+	var state resultState
+
+	if result, ok := r.results[race.Id]; ok {
+		state = resultState{
+			recorded:  true,
+			official:  result.Official,
+			protest:   result.Protest,
+			abandoned: result.Abandoned,
+		}
+	}
+
+	return raceStatus(race.AdvertisedStartTime.AsTime(), now, state)
+}
+
+// storedRace copies the writable fields of a race for storage. Start times are
+// truncated to the second, the precision they are stored at in SQL.
+func storedRace(race *racing.Race) *racing.Race {
+	stored := proto.Clone(race).(*racing.Race)
+	stored.Status = racing.Race_STATUS_UNSPECIFIED
+	stored.Runners = nil
+	stored.AdvertisedStartTime = timestamppb.New(race.AdvertisedStartTime.AsTime().Truncate(time.Second))
+
+	return stored
+}
+
+func cloneRunners(runners []*racing.Runner) []*racing.Runner {
+	if runners == nil {
+		return nil
+	}
+
+	cloned := make([]*racing.Runner, 0, len(runners))
+	for _, runner := range runners {
+		cloned = append(cloned, proto.Clone(runner).(*racing.Runner))
+	}
+
+	return cloned
+}
+
+// orderKey returns the sort key of a race, as held in page tokens.
+func orderKey(terms []orderTerm, race *racing.Race) []interface{} {
+	key := make([]interface{}, 0, len(terms))
+	for _, term := range terms {
+		key = append(key, raceOrderFields[term.field].value(race))
+	}
+
+	return key
+}
+
+// compareRaceKey compares the sort key of a race against another key under the
+// ordering, returning a negative number if the race sorts first. It is the
+// in-memory counterpart of keysetClause.
+func compareRaceKey(terms []orderTerm, race *racing.Race, key []interface{}) (int, error) {
+	for i, term := range terms {
+		cmp, err := compareOrderValues(raceOrderFields[term.field].value(race), key[i])
+		if err != nil {
+			return 0, err
+		}
+
+		if term.desc {
+			cmp = -cmp
+		}
+
+		if cmp != 0 {
+			return cmp, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// compareOrderValues compares two sort key values of the same field. Start
+// times are RFC 3339 strings in UTC, which compare chronologically as text, and
+// names compare byte by byte, as SQL is made to compare them by Bytewise.
+func compareOrderValues(a, b interface{}) (int, error) {
+	switch a := a.(type) {
+	case int64:
+		if b, ok := b.(int64); ok {
+			switch {
+			case a < b:
+				return -1, nil
+			case a > b:
+				return 1, nil
+			}
+
+			return 0, nil
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0, nil
+			case b:
+				return -1, nil
+			}
+
+			return 1, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: malformed", ErrInvalidPageToken)
+}
+
+// MemoryMeetingsRepo is a MeetingsRepo held in memory, for use alongside
+// MemoryRacesRepo. It is read only, and so safe for concurrent use.
+type MemoryMeetingsRepo struct {
+	meetings []*racing.Meeting
+}
+
+var _ MeetingsRepo = (*MemoryMeetingsRepo)(nil)
+
+// NewMemoryMeetingsRepo creates an in-memory meetings repository holding the
+// given meetings.
+func NewMemoryMeetingsRepo(meetings ...*racing.Meeting) *MemoryMeetingsRepo {
+	stored := make([]*racing.Meeting, 0, len(meetings))
+	for _, meeting := range meetings {
+		stored = append(stored, proto.Clone(meeting).(*racing.Meeting))
+	}
+
+	sort.SliceStable(stored, func(i, j int) bool {
+		a, b := stored[i], stored[j]
+
+		switch {
+		case a.Date != b.Date:
+			return a.Date < b.Date
+		case a.Venue != b.Venue:
+			return a.Venue < b.Venue
+		}
+
+		return a.Id < b.Id
+	})
+
+	return &MemoryMeetingsRepo{meetings: stored}
+}
+
+// Init does nothing, as the meetings are supplied on creation.
+func (r *MemoryMeetingsRepo) Init() error {
+	return nil
+}
+
+func (r *MemoryMeetingsRepo) List(ctx context.Context, filter *racing.ListMeetingsRequestFilter) ([]*racing.Meeting, error) {
+	if filter == nil {
+		filter = &racing.ListMeetingsRequestFilter{}
+	}
+
+	raceTypes := make(map[racing.Meeting_RaceType]bool)
+	for _, raceType := range filter.RaceTypes {
+		raceTypes[raceType] = true
+	}
+
+	venues := make(map[string]bool)
+	for _, venue := range filter.Venues {
+		venues[venue] = true
+	}
+
+	var meetings []*racing.Meeting
+
+	for _, meeting := range r.meetings {
+		if len(raceTypes) > 0 && !raceTypes[meeting.RaceType] {
+			continue
+		}
+
+		if len(venues) > 0 && !venues[meeting.Venue] {
+			continue
+		}
+
+		meetings = append(meetings, proto.Clone(meeting).(*racing.Meeting))
+	}
+
+	return meetings, nil
+}
+
+func (r *MemoryMeetingsRepo) Get(ctx context.Context, id int64) (*racing.Meeting, error) {
+	for _, meeting := range r.meetings {
+		if meeting.Id == id {
+			return proto.Clone(meeting).(*racing.Meeting), nil
+		}
+	}
+
+	return nil, ErrMeetingNotFound
+}
@@ -0,0 +1,376 @@
+package db
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds a directory of migrations per dialect, e.g.
+// migrations/sqlite and migrations/postgres, each with the same versions.
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+var (
+	// ErrMigrationLocked is returned when another process holds the migration
+	// lock for longer than we are prepared to wait.
+	ErrMigrationLocked = errors.New("migrations are locked by another process")
+
+	// ErrUnknownMigration is returned when a target version does not exist.
+	ErrUnknownMigration = errors.New("unknown migration version")
+
+	// ErrTargetBelowVersion is returned when migrating up to a version older
+	// than the current one, which only down may revert to.
+	ErrTargetBelowVersion = errors.New("target version is below the current version, use down to revert")
+)
+
+const (
+	// migrationLockWait is how long to wait for another process's lock.
+	migrationLockWait = 30 * time.Second
+
+	// migrationLockTTL is how long a lock is held before it is considered
+	// abandoned, e.g. by a process killed mid-migration, and may be taken.
+	migrationLockTTL = 5 * time.Minute
+)
+
+// Migration is a single versioned schema change.
+type Migration struct {
+	Version int
+	Name    string
+	// Up applies the change, and Down reverts it.
+	Up, Down string
+}
+
+// MigrateOptions controls how migrations are run.
+type MigrateOptions struct {
+	// DryRun reports the migrations which would run without applying them.
+	DryRun bool
+}
+
+// Migrator applies the embedded schema migrations to a database, recording
+// the applied versions in a schema_migrations table.
+type Migrator struct {
+	db         *DB
+	migrations []Migration
+	owner      string
+	lockWait   time.Duration
+	lockTTL    time.Duration
+}
+
+// NewMigrator creates a migrator for the embedded migrations of the
+// database's dialect.
+func NewMigrator(db *DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles, path.Join("migrations", db.Dialect().Name()))
+	if err != nil {
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+		owner:      fmt.Sprintf("%s:%d:%d", host, os.Getpid(), time.Now().UnixNano()),
+		lockWait:   migrationLockWait,
+		lockTTL:    migrationLockTTL,
+	}, nil
+}
+
+// Migrations returns every known migration, ordered by version.
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Version returns the latest applied migration version, or 0 if none are.
+func (m *Migrator) Version() (int, error) {
+	// Check for the table rather than creating it, so that reading the
+	// version (e.g. in a dry run) never writes to the database.
+	var exists bool
+
+	if err := m.db.QueryRow(m.db.Dialect().TableExists(), "schema_migrations").Scan(&exists); err != nil || !exists {
+		return 0, err
+	}
+
+	var version int
+
+	err := m.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+
+	return version, err
+}
+
+// Up applies pending migrations up to and including target, or all of them
+// when target is 0. It returns the migrations applied, or which would be in a
+// dry run.
+func (m *Migrator) Up(target int, opts MigrateOptions) ([]Migration, error) {
+	if target == 0 && len(m.migrations) > 0 {
+		target = m.migrations[len(m.migrations)-1].Version
+	}
+
+	return m.run(target, false, opts, func(current int) []Migration {
+		var pending []Migration
+
+		for _, migration := range m.migrations {
+			if migration.Version > current && migration.Version <= target {
+				pending = append(pending, migration)
+			}
+		}
+
+		return pending
+	})
+}
+
+// Down reverts applied migrations newer than target, so that target is the
+// latest applied version; a target of 0 reverts everything. It returns the
+// migrations reverted, or which would be in a dry run.
+func (m *Migrator) Down(target int, opts MigrateOptions) ([]Migration, error) {
+	return m.run(target, true, opts, func(current int) []Migration {
+		var pending []Migration
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if migration.Version > target && migration.Version <= current {
+				pending = append(pending, migration)
+			}
+		}
+
+		return pending
+	})
+}
+
+// run applies the migrations selected by pending under the migration lock, or
+// reverts them if down.
+func (m *Migrator) run(target int, down bool, opts MigrateOptions, pending func(current int) []Migration) ([]Migration, error) {
+	if target != 0 && !m.known(target) {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownMigration, target)
+	}
+
+	if !opts.DryRun {
+		if err := m.bootstrap(); err != nil {
+			return nil, err
+		}
+
+		if err := m.lock(); err != nil {
+			return nil, err
+		}
+		defer m.unlock()
+	}
+
+	// Read the version under the lock, as another process may have migrated
+	// while we waited for it.
+	current, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+
+	if !down && target < current {
+		return nil, fmt.Errorf("%w: %d is below %d", ErrTargetBelowVersion, target, current)
+	}
+
+	migrations := pending(current)
+	if opts.DryRun {
+		return migrations, nil
+	}
+
+	for _, migration := range migrations {
+		if err := m.apply(migration, down); err != nil {
+			return nil, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	return migrations, nil
+}
+
+// apply runs a single migration, and records it, in one transaction.
+func (m *Migrator) apply(migration Migration, down bool) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if down {
+		if _, err := tx.Exec(migration.Down); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, migration.Version); err != nil {
+			return err
+		}
+	} else {
+		if _, err := tx.Exec(migration.Up); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(
+			`INSERT INTO schema_migrations(version, name, applied_at) VALUES (?,?,?)`,
+			migration.Version,
+			migration.Name,
+			time.Now().UTC().Format(time.RFC3339),
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// bootstrap creates the tables the migrator itself relies on. Times are
+// stored as UTC RFC 3339 text, which sorts chronologically in any dialect.
+func (m *Migrator) bootstrap() error {
+	for _, query := range []string{
+		`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name TEXT, applied_at TEXT)`,
+		`CREATE TABLE IF NOT EXISTS schema_migrations_lock (id INTEGER PRIMARY KEY CHECK (id = 1), owner TEXT, acquired_at TEXT)`,
+	} {
+		if _, err := m.db.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lock takes the migration lock, waiting up to lockWait (migrationLockWait)
+// for another process to release it. Abandoned locks are taken over after
+// lockTTL (migrationLockTTL).
+func (m *Migrator) lock() error {
+	deadline := time.Now().Add(m.lockWait)
+
+	for {
+		now := time.Now().UTC()
+
+		res, err := m.db.Exec(
+			`INSERT INTO schema_migrations_lock(id, owner, acquired_at) VALUES (1, ?, ?)
+			ON CONFLICT(id) DO UPDATE SET owner = excluded.owner, acquired_at = excluded.acquired_at
+			WHERE schema_migrations_lock.acquired_at < ?`,
+			m.owner,
+			now.Format(time.RFC3339),
+			now.Add(-m.lockTTL).Format(time.RFC3339),
+		)
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 1 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return ErrMigrationLocked
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// unlock releases the migration lock, if we still hold it.
+func (m *Migrator) unlock() {
+	_, _ = m.db.Exec(`DELETE FROM schema_migrations_lock WHERE id = 1 AND owner = ?`, m.owner)
+}
+
+func (m *Migrator) known(version int) bool {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadMigrations reads migrations from the files in dir named like
+// "0001_create_races.up.sql" and "0001_create_races.down.sql". Every version
+// must have both an up and a down file.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, file := range files {
+		base := path.Base(file)
+
+		var (
+			stem string
+			up   bool
+		)
+
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			stem, up = strings.TrimSuffix(base, ".up.sql"), true
+		case strings.HasSuffix(base, ".down.sql"):
+			stem = strings.TrimSuffix(base, ".down.sql")
+		default:
+			return nil, fmt.Errorf("migration %s: must end in .up.sql or .down.sql", base)
+		}
+
+		prefix, name, ok := strings.Cut(stem, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: must be named <version>_<name>", base)
+		}
+
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: invalid version %q", base, prefix)
+		}
+
+		b, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migration %s: version %d is also named %q", base, version, migration.Name)
+		}
+
+		if up {
+			migration.Up = string(b)
+		} else {
+			migration.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s: must have both up and down files", migration.Version, migration.Name)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// migrateUp applies every pending migration. Repositories call it from Init,
+// before seeding.
+func migrateUp(db *DB) error {
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = migrator.Up(0, MigrateOptions{})
+
+	return err
+}
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// versions returns the versions of migrations, in order.
+func versions(migrations []Migration) []int {
+	var vs []int
+	for _, migration := range migrations {
+		vs = append(vs, migration.Version)
+	}
+
+	return vs
+}
+
+// tableExists reports whether the database has a table.
+func tableExists(t *testing.T, db *DB, table string) bool {
+	t.Helper()
+
+	var exists bool
+	require.NoError(t, db.QueryRow(db.Dialect().TableExists(), table).Scan(&exists))
+
+	return exists
+}
+
+func TestMigrator(t *testing.T) {
+	db := openEmptyTestDB(t)
+
+	migrator, err := NewMigrator(db)
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2, 3, 4, 5}, versions(migrator.Migrations()))
+
+	version := func() int {
+		v, err := migrator.Version()
+		require.NoError(t, err)
+
+		return v
+	}
+
+	assert.Equal(t, 0, version())
+
+	t.Run("dry run writes nothing", func(t *testing.T) {
+		pending, err := migrator.Up(0, MigrateOptions{DryRun: true})
+		require.NoError(t, err)
+
+		assert.Equal(t, []int{1, 2, 3, 4, 5}, versions(pending))
+		assert.Equal(t, 0, version())
+		assert.False(t, tableExists(t, db, "schema_migrations"))
+	})
+
+	t.Run("up to a version", func(t *testing.T) {
+		applied, err := migrator.Up(2, MigrateOptions{})
+		require.NoError(t, err)
+
+		assert.Equal(t, []int{1, 2}, versions(applied))
+		assert.Equal(t, 2, version())
+		assert.True(t, tableExists(t, db, "runners"))
+		assert.False(t, tableExists(t, db, "meetings"))
+	})
+
+	t.Run("up below the version", func(t *testing.T) {
+		_, err := migrator.Up(1, MigrateOptions{})
+		assert.ErrorIs(t, err, ErrTargetBelowVersion)
+
+		_, err = migrator.Up(1, MigrateOptions{DryRun: true})
+		assert.ErrorIs(t, err, ErrTargetBelowVersion)
+
+		assert.Equal(t, 2, version())
+	})
+
+	t.Run("up to an unknown version", func(t *testing.T) {
+		_, err := migrator.Up(99, MigrateOptions{})
+		assert.ErrorIs(t, err, ErrUnknownMigration)
+	})
+
+	t.Run("up to the latest", func(t *testing.T) {
+		applied, err := migrator.Up(0, MigrateOptions{})
+		require.NoError(t, err)
+
+		assert.Equal(t, []int{3, 4, 5}, versions(applied))
+		assert.Equal(t, 5, version())
+
+		applied, err = migrator.Up(0, MigrateOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, applied)
+	})
+
+	t.Run("dry run down", func(t *testing.T) {
+		pending, err := migrator.Down(1, MigrateOptions{DryRun: true})
+		require.NoError(t, err)
+
+		assert.Equal(t, []int{5, 4, 3, 2}, versions(pending))
+		assert.Equal(t, 5, version())
+	})
+
+	t.Run("down to a version", func(t *testing.T) {
+		reverted, err := migrator.Down(1, MigrateOptions{})
+		require.NoError(t, err)
+
+		assert.Equal(t, []int{5, 4, 3, 2}, versions(reverted))
+		assert.Equal(t, 1, version())
+		assert.True(t, tableExists(t, db, "races"))
+		assert.False(t, tableExists(t, db, "runners"))
+		assert.False(t, tableExists(t, db, "race_results"))
+	})
+
+	t.Run("down to nothing and back up", func(t *testing.T) {
+		reverted, err := migrator.Down(0, MigrateOptions{})
+		require.NoError(t, err)
+
+		assert.Equal(t, []int{1}, versions(reverted))
+		assert.Equal(t, 0, version())
+		assert.False(t, tableExists(t, db, "races"))
+
+		applied, err := migrator.Up(0, MigrateOptions{})
+		require.NoError(t, err)
+
+		assert.Equal(t, []int{1, 2, 3, 4, 5}, versions(applied))
+		assert.Equal(t, 5, version())
+	})
+}
+
+func TestMigratorLock(t *testing.T) {
+	db := openEmptyTestDB(t)
+
+	holder, err := NewMigrator(db)
+	require.NoError(t, err)
+
+	migrator, err := NewMigrator(db)
+	require.NoError(t, err)
+
+	migrator.lockWait = 200 * time.Millisecond
+
+	require.NoError(t, holder.bootstrap())
+	require.NoError(t, holder.lock())
+
+	t.Run("held", func(t *testing.T) {
+		_, err := migrator.Up(0, MigrateOptions{})
+		assert.ErrorIs(t, err, ErrMigrationLocked)
+	})
+
+	t.Run("dry runs ignore the lock", func(t *testing.T) {
+		pending, err := migrator.Up(0, MigrateOptions{DryRun: true})
+		require.NoError(t, err)
+		assert.Len(t, pending, 5)
+	})
+
+	t.Run("abandoned", func(t *testing.T) {
+		_, err := db.Exec(
+			`UPDATE schema_migrations_lock SET acquired_at = ?`,
+			time.Now().UTC().Add(-migrationLockTTL-time.Minute).Format(time.RFC3339),
+		)
+		require.NoError(t, err)
+
+		applied, err := migrator.Up(0, MigrateOptions{})
+		require.NoError(t, err)
+		assert.Len(t, applied, 5)
+	})
+
+	t.Run("released", func(t *testing.T) {
+		var n int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM schema_migrations_lock`).Scan(&n))
+		assert.Zero(t, n)
+
+		// The holder no longer owns the lock, so releasing it is harmless.
+		holder.unlock()
+		require.NoError(t, holder.lock())
+		holder.unlock()
+	})
+}
+
+func TestLoadMigrations(t *testing.T) {
+	file := func(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s)} }
+
+	migrations, err := loadMigrations(fstest.MapFS{
+		"m/0002_b.up.sql":   file("up b"),
+		"m/0002_b.down.sql": file("down b"),
+		"m/0001_a.up.sql":   file("up a"),
+		"m/0001_a.down.sql": file("down a"),
+	}, "m")
+	require.NoError(t, err)
+	assert.Equal(t, []Migration{
+		{Version: 1, Name: "a", Up: "up a", Down: "down a"},
+		{Version: 2, Name: "b", Up: "up b", Down: "down b"},
+	}, migrations)
+
+	for name, fsys := range map[string]fstest.MapFS{
+		"missing down": {"m/0001_a.up.sql": file("up")},
+		"unnamed":      {"m/0001.up.sql": file("up"), "m/0001.down.sql": file("down")},
+		"bad version":  {"m/v1_a.up.sql": file("up"), "m/v1_a.down.sql": file("down")},
+		"zero version": {"m/0000_a.up.sql": file("up"), "m/0000_a.down.sql": file("down")},
+		"renamed":      {"m/0001_a.up.sql": file("up"), "m/0001_b.down.sql": file("down")},
+		"not a step":   {"m/0001_a.sql": file("up")},
+	} {
+		_, err := loadMigrations(fsys, "m")
+		assert.Error(t, err, name)
+	}
+}
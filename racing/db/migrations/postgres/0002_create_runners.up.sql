@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS runners (
+    id BIGSERIAL PRIMARY KEY,
+    race_id BIGINT,
+    barrier INTEGER,
+    saddle_cloth_number INTEGER,
+    name TEXT,
+    jockey TEXT,
+    trainer TEXT,
+    weight DOUBLE PRECISION,
+    scratched BOOLEAN,
+    UNIQUE (race_id, saddle_cloth_number)
+);
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS race_results (
+    race_id BIGINT PRIMARY KEY,
+    official BOOLEAN,
+    protest BOOLEAN,
+    abandoned BOOLEAN
+);
+
+CREATE TABLE IF NOT EXISTS result_placings (
+    race_id BIGINT,
+    runner_id BIGINT,
+    position INTEGER,
+    margin DOUBLE PRECISION,
+    PRIMARY KEY (race_id, runner_id)
+);
+
+CREATE TABLE IF NOT EXISTS result_dividends (
+    id BIGSERIAL PRIMARY KEY,
+    race_id BIGINT,
+    bet_type INTEGER,
+    selections TEXT,
+    amount DOUBLE PRECISION
+);
@@ -0,0 +1,10 @@
+-- The initial tables are created IF NOT EXISTS, so that databases seeded
+-- before migrations were introduced are adopted in place.
+CREATE TABLE IF NOT EXISTS races (
+    id INTEGER PRIMARY KEY,
+    meeting_id INTEGER,
+    name TEXT,
+    number INTEGER,
+    visible INTEGER,
+    advertised_start_time DATETIME
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS runners (
+    id INTEGER PRIMARY KEY,
+    race_id INTEGER,
+    barrier INTEGER,
+    saddle_cloth_number INTEGER,
+    name TEXT,
+    jockey TEXT,
+    trainer TEXT,
+    weight REAL,
+    scratched INTEGER,
+    UNIQUE (race_id, saddle_cloth_number)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS meetings (
+    id INTEGER PRIMARY KEY,
+    venue TEXT,
+    state TEXT,
+    country TEXT,
+    race_type INTEGER,
+    track_condition TEXT,
+    date TEXT
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS result_dividends;
+DROP TABLE IF EXISTS result_placings;
+DROP TABLE IF EXISTS race_results;
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS race_results (
+    race_id INTEGER PRIMARY KEY,
+    official INTEGER,
+    protest INTEGER,
+    abandoned INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS result_placings (
+    race_id INTEGER,
+    runner_id INTEGER,
+    position INTEGER,
+    margin REAL,
+    PRIMARY KEY (race_id, runner_id)
+);
+
+CREATE TABLE IF NOT EXISTS result_dividends (
+    id INTEGER PRIMARY KEY,
+    race_id INTEGER,
+    bet_type INTEGER,
+    selections TEXT,
+    amount REAL
+);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS result_dividends_race_bet;
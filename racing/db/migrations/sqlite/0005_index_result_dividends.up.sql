@@ -0,0 +1 @@
+CREATE UNIQUE INDEX IF NOT EXISTS result_dividends_race_bet ON result_dividends (race_id, bet_type, selections);
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/proto/racing"
+)
+
+func (r *racesRepo) Create(ctx context.Context, races []*racing.Race) ([]*racing.Race, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids := make([]int64, 0, len(races))
+
+	for _, race := range races {
+		id, err := tx.Insert(
+			ctx,
+			`INSERT INTO races(meeting_id, name, number, visible, advertised_start_time) VALUES (?,?,?,?,?)`,
+			race.MeetingId,
+			race.Name,
+			race.Number,
+			race.Visible,
+			race.AdvertisedStartTime.AsTime().Format(time.RFC3339),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	// Read the races back, so they carry their derived status.
+	created := make([]*racing.Race, 0, len(ids))
+
+	for _, id := range ids {
+		race, err := r.Get(ctx, id, GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		created = append(created, race)
+	}
+
+	return created, nil
+}
+
+// raceColumns maps the writable fields of a race onto the values stored in
+// their columns, which share their names.
+var raceColumns = map[string]func(race *racing.Race) interface{}{
+	"meeting_id":            func(race *racing.Race) interface{} { return race.MeetingId },
+	"name":                  func(race *racing.Race) interface{} { return race.Name },
+	"number":                func(race *racing.Race) interface{} { return race.Number },
+	"visible":               func(race *racing.Race) interface{} { return race.Visible },
+	"advertised_start_time": func(race *racing.Race) interface{} { return race.AdvertisedStartTime.AsTime().Format(time.RFC3339) },
+}
+
+func (r *racesRepo) Update(ctx context.Context, race *racing.Race, fields []string) (*racing.Race, error) {
+	if len(fields) == 0 {
+		return r.Get(ctx, race.Id, GetOptions{})
+	}
+
+	var (
+		assignments = make([]string, 0, len(fields))
+		args        = make([]interface{}, 0, len(fields)+1)
+	)
+
+	for _, field := range fields {
+		value, ok := raceColumns[field]
+		if !ok {
+			return nil, fmt.Errorf("race field %q is not writable", field)
+		}
+
+		assignments = append(assignments, field+" = ?")
+		args = append(args, value(race))
+	}
+
+	// Only the named columns are written, so concurrent updates of other
+	// fields are not lost.
+	res, err := r.db.ExecContext(
+		ctx,
+		`UPDATE races SET `+strings.Join(assignments, ", ")+` WHERE id = ?`,
+		append(args, race.Id)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := expectAffected(res); err != nil {
+		return nil, err
+	}
+
+	return r.Get(ctx, race.Id, GetOptions{})
+}
+
+func (r *racesRepo) Delete(ctx context.Context, id int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM races WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	if err := expectAffected(res); err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		`DELETE FROM runners WHERE race_id = ?`,
+		`DELETE FROM race_results WHERE race_id = ?`,
+		`DELETE FROM result_placings WHERE race_id = ?`,
+		`DELETE FROM result_dividends WHERE race_id = ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// expectAffected returns ErrRaceNotFound when a statement matched no races.
+func expectAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrRaceNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/clock"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestDeleteCascades(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	db := openTestDB(t)
+	repo := NewRacesRepo(db, clock.Frozen(now), RepoOptions{})
+	require.NoError(t, repo.Init())
+
+	created, err := repo.Create(ctx, []*racing.Race{
+		{MeetingId: 1, Name: "Deleted", Number: 1, AdvertisedStartTime: timestamppb.New(now.Add(-time.Hour))},
+		{MeetingId: 1, Name: "Kept", Number: 2, AdvertisedStartTime: timestamppb.New(now.Add(-time.Hour))},
+	})
+	require.NoError(t, err)
+
+	for _, race := range created {
+		_, err := db.Exec(`INSERT INTO runners(race_id, barrier, saddle_cloth_number, name, jockey, trainer, weight, scratched) VALUES (?,1,1,'Runner','Jockey','Trainer',55,FALSE)`, race.Id)
+		require.NoError(t, err)
+
+		_, err = db.Exec(`INSERT INTO race_results(race_id, official, protest, abandoned) VALUES (?,TRUE,FALSE,FALSE)`, race.Id)
+		require.NoError(t, err)
+
+		_, err = db.Exec(`INSERT INTO result_placings(race_id, runner_id, position, margin) VALUES (?,?,1,0)`, race.Id, race.Id)
+		require.NoError(t, err)
+
+		_, err = db.Exec(`INSERT INTO result_dividends(race_id, bet_type, selections, amount) VALUES (?,1,'[1]',2.5)`, race.Id)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, repo.Delete(ctx, created[0].Id))
+	assert.ErrorIs(t, repo.Delete(ctx, created[0].Id), ErrRaceNotFound)
+
+	for _, table := range []string{"races", "runners", "race_results", "result_placings", "result_dividends"} {
+		column := "race_id"
+		if table == "races" {
+			column = "id"
+		}
+
+		for _, race := range created {
+			var n int
+			require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE `+column+` = ?`, race.Id).Scan(&n))
+
+			want := 1
+			if race.Id == created[0].Id {
+				want = 0
+			}
+
+			assert.Equal(t, want, n, "%s of race %q", table, race.Name)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/proto/racing"
+)
+
+// ErrInvalidOrderBy is returned when an order_by clause cannot be parsed or
+// references a field which races cannot be ordered by.
+var ErrInvalidOrderBy = errors.New("invalid order_by")
+
+// defaultRaceOrderBy is applied when the caller does not specify an ordering.
+const defaultRaceOrderBy = "advertised_start_time"
+
+// raceOrderField describes a field races may be ordered by.
+type raceOrderField struct {
+	// column is the column the field sorts on.
+	column string
+	// timestamp marks columns which must be compared as datetimes.
+	timestamp bool
+	// text marks columns which must be compared byte by byte, as the
+	// in-memory repository and page tokens compare them.
+	text bool
+	// value extracts the field's sort key from a race, for use in page tokens.
+	value func(race *racing.Race) interface{}
+}
+
+// raceOrderFields whitelists the fields races may be ordered by.
+var raceOrderFields = map[string]raceOrderField{
+	"id": {
+		column: "races.id",
+		value:  func(race *racing.Race) interface{} { return race.Id },
+	},
+	"meeting_id": {
+		column: "races.meeting_id",
+		value:  func(race *racing.Race) interface{} { return race.MeetingId },
+	},
+	"name": {
+		column: "races.name",
+		text:   true,
+		value:  func(race *racing.Race) interface{} { return race.Name },
+	},
+	"number": {
+		column: "races.number",
+		value:  func(race *racing.Race) interface{} { return race.Number },
+	},
+	"visible": {
+		column: "races.visible",
+		value:  func(race *racing.Race) interface{} { return race.Visible },
+	},
+	"advertised_start_time": {
+		column:    "races.advertised_start_time",
+		timestamp: true,
+		value: func(race *racing.Race) interface{} {
+			return race.AdvertisedStartTime.AsTime().Format(time.RFC3339)
+		},
+	},
+}
+
+// orderTerm is a single field of a parsed order_by.
+type orderTerm struct {
+	field string
+	desc  bool
+}
+
+// parseOrderBy parses an AIP-132 style order_by string, such as
+// "advertised_start_time desc, number". The id is always appended as a final
+// tie-breaker so that the ordering is total, which keyset paging relies on.
+func parseOrderBy(orderBy string) ([]orderTerm, error) {
+	if strings.TrimSpace(orderBy) == "" {
+		orderBy = defaultRaceOrderBy
+	}
+
+	var (
+		terms []orderTerm
+		seen  = make(map[string]bool)
+	)
+
+	for _, part := range strings.Split(orderBy, ",") {
+		tokens := strings.Fields(part)
+		if len(tokens) == 0 || len(tokens) > 2 {
+			return nil, fmt.Errorf("%w: malformed term %q", ErrInvalidOrderBy, strings.TrimSpace(part))
+		}
+
+		if _, ok := raceOrderFields[tokens[0]]; !ok {
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidOrderBy, tokens[0])
+		}
+
+		if seen[tokens[0]] {
+			return nil, fmt.Errorf("%w: duplicate field %q", ErrInvalidOrderBy, tokens[0])
+		}
+		seen[tokens[0]] = true
+
+		term := orderTerm{field: tokens[0]}
+		if len(tokens) == 2 {
+			switch strings.ToLower(tokens[1]) {
+			case "asc":
+			case "desc":
+				term.desc = true
+			default:
+				return nil, fmt.Errorf("%w: unknown direction %q", ErrInvalidOrderBy, tokens[1])
+			}
+		}
+
+		terms = append(terms, term)
+	}
+
+	if !seen["id"] {
+		terms = append(terms, orderTerm{field: "id"})
+	}
+
+	return terms, nil
+}
+
+// sql returns the SQL expression the field sorts on, and a placeholder which
+// compares against it.
+func (f raceOrderField) sql(dialect Dialect) (expr, param string) {
+	if f.timestamp {
+		return dialect.Datetime(f.column), dialect.Datetime("?")
+	}
+
+	if f.text {
+		return dialect.Bytewise(f.column), "?"
+	}
+
+	return f.column, "?"
+}
+
+// orderByClause renders terms as an SQL ORDER BY clause.
+func orderByClause(dialect Dialect, terms []orderTerm) string {
+	parts := make([]string, 0, len(terms))
+
+	for _, term := range terms {
+		direction := "ASC"
+		if term.desc {
+			direction = "DESC"
+		}
+
+		expr, _ := raceOrderFields[term.field].sql(dialect)
+		parts = append(parts, expr+" "+direction)
+	}
+
+	return " ORDER BY " + strings.Join(parts, ", ")
+}
+
+// canonicalOrderBy renders terms back into a normalised order_by string.
+func canonicalOrderBy(terms []orderTerm) string {
+	parts := make([]string, 0, len(terms))
+
+	for _, term := range terms {
+		if term.desc {
+			parts = append(parts, term.field+" desc")
+			continue
+		}
+
+		parts = append(parts, term.field)
+	}
+
+	return strings.Join(parts, ", ")
+}
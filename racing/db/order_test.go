@@ -0,0 +1,68 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOrderBy(t *testing.T) {
+	for _, tc := range []struct {
+		orderBy string
+		want    []orderTerm
+		err     error
+	}{
+		{
+			orderBy: "",
+			want:    []orderTerm{{field: "advertised_start_time"}, {field: "id"}},
+		},
+		{
+			orderBy: "  ",
+			want:    []orderTerm{{field: "advertised_start_time"}, {field: "id"}},
+		},
+		{
+			orderBy: "name desc, number",
+			want:    []orderTerm{{field: "name", desc: true}, {field: "number"}, {field: "id"}},
+		},
+		{
+			orderBy: "meeting_id ASC,advertised_start_time DESC",
+			want:    []orderTerm{{field: "meeting_id"}, {field: "advertised_start_time", desc: true}, {field: "id"}},
+		},
+		{
+			orderBy: "id desc, name",
+			want:    []orderTerm{{field: "id", desc: true}, {field: "name"}},
+		},
+		{orderBy: "name, number, name desc", err: ErrInvalidOrderBy},
+		{orderBy: "status", err: ErrInvalidOrderBy},
+		{orderBy: "races.name", err: ErrInvalidOrderBy},
+		{orderBy: "name sideways", err: ErrInvalidOrderBy},
+		{orderBy: "name desc nulls", err: ErrInvalidOrderBy},
+		{orderBy: "name,", err: ErrInvalidOrderBy},
+		{orderBy: ",name", err: ErrInvalidOrderBy},
+	} {
+		t.Run(tc.orderBy, func(t *testing.T) {
+			terms, err := parseOrderBy(tc.orderBy)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, terms)
+		})
+	}
+}
+
+func TestOrderByClause(t *testing.T) {
+	terms := []orderTerm{{field: "advertised_start_time", desc: true}, {field: "name"}, {field: "id"}}
+
+	assert.Equal(t,
+		" ORDER BY datetime(races.advertised_start_time) DESC, races.name ASC, races.id ASC",
+		orderByClause(sqliteDialect{}, terms),
+	)
+	assert.Equal(t,
+		` ORDER BY races.advertised_start_time DESC, races.name COLLATE "C" ASC, races.id ASC`,
+		orderByClause(postgresDialect{}, terms),
+	)
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// ErrInvalidPageSize is returned when a negative page size is requested.
+	ErrInvalidPageSize = errors.New("invalid page_size")
+
+	// ErrInvalidPageToken is returned when a page token is malformed, or was
+	// issued for a different filter or ordering.
+	ErrInvalidPageToken = errors.New("invalid page_token")
+)
+
+const (
+	// defaultPageSize is used when the caller does not specify a page size.
+	defaultPageSize = 100
+
+	// MaxPageSize caps the page size; larger requests are coerced down.
+	MaxPageSize = 1000
+)
+
+// ListOptions controls the ordering, pagination and view of listed races.
+type ListOptions struct {
+	// OrderBy is an AIP-132 style order_by string.
+	OrderBy string
+	// PageSize is the maximum number of races to return.
+	PageSize int32
+	// PageToken continues a previous listing.
+	PageToken string
+	// View selects whether each race's runners are embedded.
+	View racing.RaceView
+}
+
+// RacesPage is a single page of listed races.
+type RacesPage struct {
+	Races []*racing.Race
+	// NextPageToken fetches the following page; empty on the last page.
+	NextPageToken string
+	// TotalSize is the number of races matching the filter across all pages.
+	TotalSize int32
+}
+
+// pageToken is the decoded form of an opaque page token. Tokens are keyset
+// based, so pages stay stable while races are added or removed.
+type pageToken struct {
+	// Fingerprint identifies the filter and ordering the token was issued for.
+	Fingerprint string `json:"f"`
+	// After is the sort key of the last race on the previous page.
+	After []interface{} `json:"a"`
+}
+
+// pageSize validates the requested page size, applying the default and cap.
+func pageSize(size int32) (int, error) {
+	switch {
+	case size < 0:
+		return 0, fmt.Errorf("%w: must not be negative", ErrInvalidPageSize)
+	case size == 0:
+		return defaultPageSize, nil
+	case size > MaxPageSize:
+		return MaxPageSize, nil
+	}
+
+	return int(size), nil
+}
+
+// listFingerprint summarises the filter and ordering of a listing, so that a
+// token cannot be replayed against a different query.
+func listFingerprint(filter *racing.ListRacesRequestFilter, terms []orderTerm) (string, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(filter)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.New()
+	sum.Write(b)
+	sum.Write([]byte{0})
+	sum.Write([]byte(canonicalOrderBy(terms)))
+
+	return base64.RawURLEncoding.EncodeToString(sum.Sum(nil)[:12]), nil
+}
+
+// newPageToken encodes a token continuing after the given race.
+func newPageToken(fingerprint string, terms []orderTerm, last *racing.Race) (string, error) {
+	token := pageToken{Fingerprint: fingerprint}
+
+	for _, term := range terms {
+		token.After = append(token.After, raceOrderFields[term.field].value(last))
+	}
+
+	b, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// parsePageToken decodes and validates a token against the current listing.
+func parsePageToken(s, fingerprint string, terms []orderTerm) (*pageToken, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidPageToken)
+	}
+
+	var token pageToken
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	if err := dec.Decode(&token); err != nil {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidPageToken)
+	}
+
+	if token.Fingerprint != fingerprint || len(token.After) != len(terms) {
+		return nil, fmt.Errorf("%w: filter or order_by changed between pages", ErrInvalidPageToken)
+	}
+
+	for i, v := range token.After {
+		if n, ok := v.(json.Number); ok {
+			if token.After[i], err = n.Int64(); err != nil {
+				return nil, fmt.Errorf("%w: malformed", ErrInvalidPageToken)
+			}
+		}
+	}
+
+	return &token, nil
+}
+
+// keysetClause builds the condition selecting races which sort after the
+// token's key, e.g. for "a, b desc": ((a > ?) OR (a = ? AND b < ?)).
+func keysetClause(dialect Dialect, terms []orderTerm, after []interface{}) (string, []interface{}) {
+	var (
+		alternatives []string
+		args         []interface{}
+	)
+
+	for i, term := range terms {
+		var conds []string
+
+		for j := 0; j < i; j++ {
+			expr, param := raceOrderFields[terms[j].field].sql(dialect)
+			conds = append(conds, expr+" = "+param)
+			args = append(args, after[j])
+		}
+
+		op := " > "
+		if term.desc {
+			op = " < "
+		}
+
+		expr, param := raceOrderFields[term.field].sql(dialect)
+		conds = append(conds, expr+op+param)
+		args = append(args, after[i])
+
+		alternatives = append(alternatives, "("+strings.Join(conds, " AND ")+")")
+	}
+
+	return "(" + strings.Join(alternatives, " OR ") + ")", args
+}
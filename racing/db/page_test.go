@@ -0,0 +1,221 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestPageSize(t *testing.T) {
+	for _, tc := range []struct {
+		size int32
+		want int
+		err  bool
+	}{
+		{size: 0, want: defaultPageSize},
+		{size: 1, want: 1},
+		{size: MaxPageSize, want: MaxPageSize},
+		{size: MaxPageSize + 1, want: MaxPageSize},
+		{size: -1, err: true},
+	} {
+		size, err := pageSize(tc.size)
+		if tc.err {
+			assert.ErrorIs(t, err, ErrInvalidPageSize, "size %d", tc.size)
+			continue
+		}
+
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, size, "size %d", tc.size)
+	}
+}
+
+func TestKeysetClause(t *testing.T) {
+	start := "2021-03-02T10:00:00Z"
+
+	for _, tc := range []struct {
+		name    string
+		dialect Dialect
+		terms   []orderTerm
+		after   []interface{}
+		want    string
+		args    []interface{}
+	}{
+		{
+			name:    "id",
+			dialect: sqliteDialect{},
+			terms:   []orderTerm{{field: "id"}},
+			after:   []interface{}{int64(7)},
+			want:    "((races.id > ?))",
+			args:    []interface{}{int64(7)},
+		},
+		{
+			name:    "mixed directions",
+			dialect: sqliteDialect{},
+			terms:   []orderTerm{{field: "name"}, {field: "number", desc: true}, {field: "id"}},
+			after:   []interface{}{"Cup", int64(3), int64(7)},
+			want: "((races.name > ?) OR " +
+				"(races.name = ? AND races.number < ?) OR " +
+				"(races.name = ? AND races.number = ? AND races.id > ?))",
+			args: []interface{}{"Cup", "Cup", int64(3), "Cup", int64(3), int64(7)},
+		},
+		{
+			name:    "descending start time in sqlite",
+			dialect: sqliteDialect{},
+			terms:   []orderTerm{{field: "advertised_start_time", desc: true}, {field: "id", desc: true}},
+			after:   []interface{}{start, int64(7)},
+			want: "((datetime(races.advertised_start_time) < datetime(?)) OR " +
+				"(datetime(races.advertised_start_time) = datetime(?) AND races.id < ?))",
+			args: []interface{}{start, start, int64(7)},
+		},
+		{
+			name:    "name in postgres",
+			dialect: postgresDialect{},
+			terms:   []orderTerm{{field: "name", desc: true}, {field: "id"}},
+			after:   []interface{}{"Cup", int64(7)},
+			want: `((races.name COLLATE "C" < ?) OR ` +
+				`(races.name COLLATE "C" = ? AND races.id > ?))`,
+			args: []interface{}{"Cup", "Cup", int64(7)},
+		},
+		{
+			name:    "start time in postgres",
+			dialect: postgresDialect{},
+			terms:   []orderTerm{{field: "advertised_start_time"}, {field: "id"}},
+			after:   []interface{}{start, int64(7)},
+			want: "((races.advertised_start_time > ?) OR " +
+				"(races.advertised_start_time = ? AND races.id > ?))",
+			args: []interface{}{start, start, int64(7)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clause, args := keysetClause(tc.dialect, tc.terms, tc.after)
+
+			assert.Equal(t, tc.want, clause)
+			assert.Equal(t, tc.args, args)
+		})
+	}
+}
+
+func TestPageToken(t *testing.T) {
+	visible := true
+	filter := &racing.ListRacesRequestFilter{MeetingIds: []int64{1, 2}, Visible: &visible}
+
+	terms, err := parseOrderBy("name desc, advertised_start_time, visible")
+	require.NoError(t, err)
+
+	fingerprint, err := listFingerprint(filter, terms)
+	require.NoError(t, err)
+
+	last := &racing.Race{
+		Id:                  42,
+		Name:                "Cup",
+		Visible:             true,
+		AdvertisedStartTime: timestamppb.New(time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)),
+	}
+
+	token, err := newPageToken(fingerprint, terms, last)
+	require.NoError(t, err)
+
+	t.Run("round trip", func(t *testing.T) {
+		parsed, err := parsePageToken(token, fingerprint, terms)
+		require.NoError(t, err)
+
+		assert.Equal(t, []interface{}{"Cup", "2021-03-02T10:00:00Z", true, int64(42)}, parsed.After)
+	})
+
+	t.Run("fingerprint is stable", func(t *testing.T) {
+		again, err := listFingerprint(
+			&racing.ListRacesRequestFilter{MeetingIds: []int64{1, 2}, Visible: &visible},
+			[]orderTerm{{field: "name", desc: true}, {field: "advertised_start_time"}, {field: "visible"}, {field: "id"}},
+		)
+		require.NoError(t, err)
+
+		assert.Equal(t, fingerprint, again)
+	})
+
+	encode := func(t *testing.T, v interface{}) string {
+		b, err := json.Marshal(v)
+		require.NoError(t, err)
+
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		token   func(t *testing.T) string
+		filter  *racing.ListRacesRequestFilter
+		orderBy string
+	}{
+		{
+			name:  "not base64",
+			token: func(*testing.T) string { return "not a token!" },
+		},
+		{
+			name:  "not json",
+			token: func(*testing.T) string { return base64.RawURLEncoding.EncodeToString([]byte("{")) },
+		},
+		{
+			name: "tampered key",
+			token: func(t *testing.T) string {
+				return encode(t, map[string]interface{}{"f": fingerprint, "a": []interface{}{"Cup", "2021-03-02T10:00:00Z", true, 4.2}})
+			},
+		},
+		{
+			name: "tampered fingerprint",
+			token: func(t *testing.T) string {
+				return encode(t, map[string]interface{}{"f": "AAAAAAAAAAAAAAAA", "a": []interface{}{"Cup", "2021-03-02T10:00:00Z", true, 42}})
+			},
+		},
+		{
+			name: "missing key",
+			token: func(t *testing.T) string {
+				return encode(t, map[string]interface{}{"f": fingerprint, "a": []interface{}{"Cup", 42}})
+			},
+		},
+		{
+			name:   "changed filter",
+			token:  func(*testing.T) string { return token },
+			filter: &racing.ListRacesRequestFilter{MeetingIds: []int64{1}, Visible: &visible},
+		},
+		{
+			name:   "cleared filter",
+			token:  func(*testing.T) string { return token },
+			filter: &racing.ListRacesRequestFilter{},
+		},
+		{
+			name:    "changed order_by",
+			token:   func(*testing.T) string { return token },
+			orderBy: "name, advertised_start_time, visible",
+		},
+		{
+			name:    "reordered order_by",
+			token:   func(*testing.T) string { return token },
+			orderBy: "advertised_start_time, name desc, visible",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			filter, terms := filter, terms
+
+			if tc.filter != nil {
+				filter = tc.filter
+			}
+
+			if tc.orderBy != "" {
+				var err error
+				terms, err = parseOrderBy(tc.orderBy)
+				require.NoError(t, err)
+			}
+
+			fingerprint, err := listFingerprint(filter, terms)
+			require.NoError(t, err)
+
+			_, err = parsePageToken(tc.token(t), fingerprint, terms)
+			assert.ErrorIs(t, err, ErrInvalidPageToken)
+		})
+	}
+}
@@ -1,20 +1,88 @@
 package db
 
 const (
-	racesList = "list"
+	racesList     = "list"
+	racesCount    = "count"
+	runnersList   = "runners"
+	meetingsList  = "meetings"
+	resultGet     = "result"
+	placingsList  = "placings"
+	dividendsList = "dividends"
 )
 
 func getRaceQueries() map[string]string {
 	return map[string]string{
 		racesList: `
 			SELECT 
-				id, 
-				meeting_id, 
-				name, 
-				number, 
-				visible, 
-				advertised_start_time 
+				races.id, 
+				races.meeting_id, 
+				races.name, 
+				races.number, 
+				races.visible, 
+				races.advertised_start_time, 
+				race_results.race_id IS NOT NULL, 
+				COALESCE(race_results.official, FALSE), 
+				COALESCE(race_results.protest, FALSE), 
+				COALESCE(race_results.abandoned, FALSE) 
 			FROM races
+			LEFT JOIN race_results ON race_results.race_id = races.id
+		`,
+		racesCount: `
+			SELECT COUNT(*) FROM races
+			LEFT JOIN race_results ON race_results.race_id = races.id
+		`,
+		runnersList: `
+			SELECT
+				id,
+				race_id,
+				barrier,
+				saddle_cloth_number,
+				name,
+				jockey,
+				trainer,
+				weight,
+				scratched
+			FROM runners
+		`,
+		meetingsList: `
+			SELECT
+				id,
+				venue,
+				state,
+				country,
+				race_type,
+				track_condition,
+				date
+			FROM meetings
+		`,
+		resultGet: `
+			SELECT
+				race_id,
+				official,
+				protest,
+				abandoned
+			FROM race_results
+			WHERE race_id = ?
+		`,
+		placingsList: `
+			SELECT
+				result_placings.runner_id,
+				runners.saddle_cloth_number,
+				result_placings.position,
+				result_placings.margin
+			FROM result_placings
+			JOIN runners ON runners.id = result_placings.runner_id
+			WHERE result_placings.race_id = ?
+			ORDER BY result_placings.position
+		`,
+		dividendsList: `
+			SELECT
+				bet_type,
+				selections,
+				amount
+			FROM result_dividends
+			WHERE race_id = ?
+			ORDER BY bet_type, id
 		`,
 	}
 }
@@ -1,33 +1,72 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"strings"
 	"sync"
 	"time"
 
+	"git.neds.sh/matty/entain/racing/clock"
 	"git.neds.sh/matty/entain/racing/proto/racing"
-	_ "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-// RacesRepo provides repository access to races.
+// ErrRaceNotFound is returned when a requested race does not exist.
+var ErrRaceNotFound = errors.New("race not found")
+
+// RacesRepo provides repository access to races. Every method but Init stops
+// querying, and returns the context's error, once its context is done.
 type RacesRepo interface {
 	// Init will initialise our races repository.
 	Init() error
 
-	// List will return a list of races.
-	List(filter *racing.ListRacesRequestFilter) ([]*racing.Race, error)
+	// List will return a page of races matching the filter.
+	List(ctx context.Context, filter *racing.ListRacesRequestFilter, opts ListOptions) (*RacesPage, error)
+
+	// Get will return a single race by its ID, or ErrRaceNotFound.
+	Get(ctx context.Context, id int64, opts GetOptions) (*racing.Race, error)
+
+	// ListRunners will return the runners of a race, or ErrRaceNotFound.
+	ListRunners(ctx context.Context, raceID int64) ([]*racing.Runner, error)
+
+	// GetResult will return the result of a race, or ErrRaceNotFound if the
+	// race does not exist and ErrResultNotFound if it has no result yet.
+	GetResult(ctx context.Context, raceID int64) (*racing.RaceResult, error)
+
+	// Create will insert the races atomically, returning them with their
+	// assigned IDs.
+	Create(ctx context.Context, races []*racing.Race) ([]*racing.Race, error)
+
+	// Update will overwrite the named writable fields of a race, e.g.
+	// "name", leaving the others as they are, or return ErrRaceNotFound.
+	Update(ctx context.Context, race *racing.Race, fields []string) (*racing.Race, error)
+
+	// Delete will delete a race along with its runners and result, or return
+	// ErrRaceNotFound.
+	Delete(ctx context.Context, id int64) error
+}
+
+// GetOptions controls how much of a fetched race is returned.
+type GetOptions struct {
+	// View selects whether the race's runners are embedded.
+	View racing.RaceView
 }
 
 type racesRepo struct {
-	db   *sql.DB
-	init sync.Once
+	db    *DB
+	clock clock.Clock
+	opts  RepoOptions
+	init  sync.Once
 }
 
-// NewRacesRepo creates a new races repository.
-func NewRacesRepo(db *sql.DB) RacesRepo {
-	return &racesRepo{db: db}
+// NewRacesRepo creates a new races repository. The clock is used to derive
+// each race's status from its advertised start time.
+func NewRacesRepo(db *DB, clock clock.Clock, opts RepoOptions) RacesRepo {
+	return &racesRepo{db: db, clock: clock, opts: opts}
 }
 
 // Init prepares the race repository dummy data.
@@ -35,50 +74,221 @@ func (r *racesRepo) Init() error {
 	var err error
 
 	r.init.Do(func() {
+		if err = migrateUp(r.db); err != nil {
+			return
+		}
+
 		// For test/example purposes, we seed the DB with some dummy races.
-		err = r.seed()
+		if r.opts.Seed {
+			err = r.seed()
+		}
 	})
 
 	return err
 }
 
-func (r *racesRepo) List(filter *racing.ListRacesRequestFilter) ([]*racing.Race, error) {
+func (r *racesRepo) List(ctx context.Context, filter *racing.ListRacesRequestFilter, opts ListOptions) (*RacesPage, error) {
+	ctx, span := tracer.Start(ctx, "racesRepo.List", trace.WithAttributes(
+		attribute.String("racing.filter", filter.String()),
+		attribute.String("racing.order_by", opts.OrderBy),
+		attribute.Int("racing.page_size", int(opts.PageSize)),
+	))
+
+	page, err := r.list(ctx, filter, opts)
+	if err == nil {
+		span.SetAttributes(
+			attribute.Int("racing.races", len(page.Races)),
+			attribute.Int("racing.total_size", int(page.TotalSize)),
+		)
+	}
+
+	endSpan(span, err)
+
+	return page, err
+}
+
+// list implements List, within its span.
+func (r *racesRepo) list(ctx context.Context, filter *racing.ListRacesRequestFilter, opts ListOptions) (*RacesPage, error) {
 	var (
 		err   error
 		query string
 		args  []interface{}
+		page  RacesPage
 	)
 
-	query = getRaceQueries()[racesList]
+	// Capture now once so that the status filter and the derived statuses agree.
+	now := r.clock.Now()
 
-	query, args = r.applyFilter(query, filter)
+	terms, err := parseOrderBy(opts.OrderBy)
+	if err != nil {
+		return nil, err
+	}
 
-	rows, err := r.db.Query(query, args...)
+	size, err := pageSize(opts.PageSize)
 	if err != nil {
 		return nil, err
 	}
 
-	return r.scanRaces(rows)
-}
+	fingerprint, err := listFingerprint(filter, terms)
+	if err != nil {
+		return nil, err
+	}
 
-func (r *racesRepo) applyFilter(query string, filter *racing.ListRacesRequestFilter) (string, []interface{}) {
 	var (
-		clauses []string
-		args    []interface{}
+		keyset     []string
+		keysetArgs []interface{}
 	)
 
+	if opts.PageToken != "" {
+		token, err := parsePageToken(opts.PageToken, fingerprint, terms)
+		if err != nil {
+			return nil, err
+		}
+
+		clause, clauseArgs := keysetClause(r.db.Dialect(), terms, token.After)
+		keyset, keysetArgs = []string{clause}, clauseArgs
+	}
+
+	query, args = r.applyFilter(getRaceQueries()[racesCount], filter, now, nil, nil)
+
+	countCtx, span := r.db.startQuerySpan(ctx, "races.count", query)
+	err = r.db.QueryRowContext(countCtx, query, args...).Scan(&page.TotalSize)
+	endSpan(span, err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	query, args = r.applyFilter(getRaceQueries()[racesList], filter, now, keyset, keysetArgs)
+
+	// Fetch one race beyond the page to learn whether another page follows.
+	query += orderByClause(r.db.Dialect(), terms) + " LIMIT ?"
+	args = append(args, size+1)
+
+	page.Races, err = r.queryRaces(ctx, query, args, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(page.Races) > size {
+		page.Races = page.Races[:size]
+
+		page.NextPageToken, err = newPageToken(fingerprint, terms, page.Races[size-1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.View == racing.RaceView_RACE_VIEW_FULL {
+		if err := r.loadRunners(ctx, page.Races); err != nil {
+			return nil, err
+		}
+	}
+
+	return &page, nil
+}
+
+// queryRaces runs a query listing races, within a span recording its SQL and
+// the number of races returned.
+func (r *racesRepo) queryRaces(ctx context.Context, query string, args []interface{}, now time.Time) ([]*racing.Race, error) {
+	ctx, span := r.db.startQuerySpan(ctx, "races.list", query)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	races, err := r.scanRaces(rows, now)
+	span.SetAttributes(rowsReturnedKey.Int(len(races)))
+	endSpan(span, err)
+
+	return races, err
+}
+
+func (r *racesRepo) Get(ctx context.Context, id int64, opts GetOptions) (*racing.Race, error) {
+	query := getRaceQueries()[racesList] + " WHERE races.id = ?"
+
+	races, err := r.queryRaces(ctx, query, []interface{}{id}, r.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(races) == 0 {
+		return nil, ErrRaceNotFound
+	}
+
+	if opts.View == racing.RaceView_RACE_VIEW_FULL {
+		if err := r.loadRunners(ctx, races); err != nil {
+			return nil, err
+		}
+	}
+
+	return races[0], nil
+}
+
+// raceExists reports whether a race with the given ID exists.
+func (r *racesRepo) raceExists(ctx context.Context, id int64) (bool, error) {
+	var exists bool
+
+	err := r.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM races WHERE id = ?)", id).Scan(&exists)
+
+	return exists, err
+}
+
+// applyFilter appends a WHERE clause for the filter to query, along with any
+// additional clauses (and their args) supplied by the caller. Meetings are
+// joined when the filter references them.
+func (r *racesRepo) applyFilter(
+	query string,
+	filter *racing.ListRacesRequestFilter,
+	now time.Time,
+	clauses []string,
+	args []interface{},
+) (string, []interface{}) {
 	if filter == nil {
-		return query, args
+		filter = &racing.ListRacesRequestFilter{}
 	}
 
 	if len(filter.MeetingIds) > 0 {
-		clauses = append(clauses, "meeting_id IN ("+strings.Repeat("?,", len(filter.MeetingIds)-1)+"?)")
+		clauses = append(clauses, "races.meeting_id IN ("+strings.Repeat("?,", len(filter.MeetingIds)-1)+"?)")
 
 		for _, meetingID := range filter.MeetingIds {
 			args = append(args, meetingID)
 		}
 	}
 
+	if filter.Visible != nil {
+		clauses = append(clauses, "races.visible = ?")
+		args = append(args, filter.GetVisible())
+	}
+
+	// These conditions must agree with raceStatus. Races starting exactly now
+	// are considered closed.
+	start, param := r.db.Dialect().Datetime("races.advertised_start_time"), r.db.Dialect().Datetime("?")
+
+	switch filter.Status {
+	case racing.Race_OPEN:
+		clauses = append(clauses, "race_results.race_id IS NULL AND "+start+" > "+param)
+		args = append(args, now.Format(time.RFC3339))
+	case racing.Race_CLOSED:
+		clauses = append(clauses, "race_results.race_id IS NULL AND "+start+" <= "+param)
+		args = append(args, now.Format(time.RFC3339))
+	case racing.Race_INTERIM:
+		clauses = append(clauses, "race_results.abandoned = FALSE AND (race_results.official = FALSE OR race_results.protest = TRUE)")
+	case racing.Race_FINAL:
+		clauses = append(clauses, "race_results.abandoned = FALSE AND race_results.official = TRUE AND race_results.protest = FALSE")
+	case racing.Race_ABANDONED:
+		clauses = append(clauses, "race_results.abandoned = TRUE")
+	}
+
+	if len(filter.RaceTypes) > 0 || len(filter.Venues) > 0 {
+		query += " JOIN meetings ON meetings.id = races.meeting_id"
+
+		clauses, args = meetingClauses(filter.RaceTypes, filter.Venues, clauses, args)
+	}
+
 	if len(clauses) != 0 {
 		query += " WHERE " + strings.Join(clauses, " AND ")
 	}
@@ -88,14 +298,19 @@ func (r *racesRepo) applyFilter(query string, filter *racing.ListRacesRequestFil
 
 func (m *racesRepo) scanRaces(
 	rows *sql.Rows,
+	now time.Time,
 ) ([]*racing.Race, error) {
 	var races []*racing.Race
 
 	for rows.Next() {
 		var race racing.Race
 		var advertisedStart time.Time
+		var result resultState
 
-		if err := rows.Scan(&race.Id, &race.MeetingId, &race.Name, &race.Number, &race.Visible, &advertisedStart); err != nil {
+		if err := rows.Scan(
+			&race.Id, &race.MeetingId, &race.Name, &race.Number, &race.Visible, &advertisedStart,
+			&result.recorded, &result.official, &result.protest, &result.abandoned,
+		); err != nil {
 			if err == sql.ErrNoRows {
 				return nil, nil
 			}
@@ -106,9 +321,36 @@ func (m *racesRepo) scanRaces(
 		ts := timestamppb.New(advertisedStart)
 
 		race.AdvertisedStartTime = ts
+		race.Status = raceStatus(advertisedStart, now, result)
 
 		races = append(races, &race)
 	}
 
-	return races, nil
+	return races, rows.Err()
+}
+
+// resultState summarises the recorded result of a race, if any.
+type resultState struct {
+	recorded  bool
+	official  bool
+	protest   bool
+	abandoned bool
+}
+
+// raceStatus derives a race's status. Once a result is recorded the status
+// follows it; until then, races which have started (or are starting now) are
+// closed.
+func raceStatus(advertisedStart, now time.Time, result resultState) racing.Race_Status {
+	switch {
+	case result.abandoned:
+		return racing.Race_ABANDONED
+	case result.recorded && result.official && !result.protest:
+		return racing.Race_FINAL
+	case result.recorded:
+		return racing.Race_INTERIM
+	case advertisedStart.After(now):
+		return racing.Race_OPEN
+	}
+
+	return racing.Race_CLOSED
 }
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/clock"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestRaceStatus(t *testing.T) {
+	now := time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name   string
+		start  time.Time
+		result resultState
+		want   racing.Race_Status
+	}{
+		{"future", now.Add(time.Second), resultState{}, racing.Race_OPEN},
+		{"starting now", now, resultState{}, racing.Race_CLOSED},
+		{"started", now.Add(-time.Hour), resultState{}, racing.Race_CLOSED},
+		{"official", now.Add(-time.Hour), resultState{recorded: true, official: true}, racing.Race_FINAL},
+		{"unofficial", now.Add(-time.Hour), resultState{recorded: true}, racing.Race_INTERIM},
+		{"protested", now.Add(-time.Hour), resultState{recorded: true, official: true, protest: true}, racing.Race_INTERIM},
+		{"abandoned", now.Add(-time.Hour), resultState{recorded: true, official: true, abandoned: true}, racing.Race_ABANDONED},
+		{"resulted before start", now.Add(time.Hour), resultState{recorded: true, official: true}, racing.Race_FINAL},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, raceStatus(tc.start, now, tc.result))
+		})
+	}
+}
+
+// TestStatusFilter checks the SQL status filter agrees with raceStatus at a
+// frozen instant, including for a race starting exactly then.
+func TestStatusFilter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	db := openTestDB(t)
+	repo := NewRacesRepo(db, clock.Frozen(now), RepoOptions{})
+	require.NoError(t, repo.Init())
+
+	fixtures := []struct {
+		start  time.Duration
+		result *resultState
+		want   racing.Race_Status
+	}{
+		{-time.Hour, nil, racing.Race_CLOSED},
+		{-time.Second, nil, racing.Race_CLOSED},
+		{0, nil, racing.Race_CLOSED},
+		{time.Second, nil, racing.Race_OPEN},
+		{time.Hour, nil, racing.Race_OPEN},
+		{-time.Hour, &resultState{official: true}, racing.Race_FINAL},
+		{-time.Hour, &resultState{}, racing.Race_INTERIM},
+		{-time.Hour, &resultState{official: true, protest: true}, racing.Race_INTERIM},
+		{-time.Hour, &resultState{official: true, abandoned: true}, racing.Race_ABANDONED},
+		{time.Hour, &resultState{official: true}, racing.Race_FINAL},
+	}
+
+	races := make([]*racing.Race, 0, len(fixtures))
+	for _, fixture := range fixtures {
+		races = append(races, &racing.Race{
+			MeetingId:           1,
+			Name:                "Race",
+			Number:              1,
+			AdvertisedStartTime: timestamppb.New(now.Add(fixture.start)),
+		})
+	}
+
+	created, err := repo.Create(ctx, races)
+	require.NoError(t, err)
+
+	want := make(map[int64]racing.Race_Status)
+
+	for i, fixture := range fixtures {
+		want[created[i].Id] = fixture.want
+
+		if result := fixture.result; result != nil {
+			_, err := db.Exec(
+				`INSERT INTO race_results(race_id, official, protest, abandoned) VALUES (?,?,?,?)`,
+				created[i].Id, result.official, result.protest, result.abandoned,
+			)
+			require.NoError(t, err)
+		}
+	}
+
+	all, err := repo.List(ctx, nil, ListOptions{})
+	require.NoError(t, err)
+
+	derived := make(map[int64]racing.Race_Status)
+	for _, race := range all.Races {
+		derived[race.Id] = race.Status
+	}
+
+	require.Equal(t, want, derived)
+
+	for status := range racing.Race_Status_name {
+		status := racing.Race_Status(status)
+		if status == racing.Race_STATUS_UNSPECIFIED {
+			continue
+		}
+
+		t.Run(status.String(), func(t *testing.T) {
+			page, err := repo.List(ctx, &racing.ListRacesRequestFilter{Status: status}, ListOptions{})
+			require.NoError(t, err)
+
+			var wantIDs, gotIDs []int64
+
+			for _, race := range created {
+				if derived[race.Id] == status {
+					wantIDs = append(wantIDs, race.Id)
+				}
+			}
+
+			for _, race := range page.Races {
+				assert.Equal(t, status, race.Status)
+				gotIDs = append(gotIDs, race.Id)
+			}
+
+			assert.ElementsMatch(t, wantIDs, gotIDs)
+			assert.EqualValues(t, len(wantIDs), page.TotalSize)
+		})
+	}
+}
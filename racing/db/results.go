@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"git.neds.sh/matty/entain/racing/proto/racing"
+)
+
+// ErrResultNotFound is returned when a race has no recorded result.
+var ErrResultNotFound = errors.New("result not found")
+
+func (r *racesRepo) GetResult(ctx context.Context, raceID int64) (*racing.RaceResult, error) {
+	var result racing.RaceResult
+
+	err := r.db.QueryRowContext(ctx, getRaceQueries()[resultGet], raceID).Scan(
+		&result.RaceId,
+		&result.Official,
+		&result.Protest,
+		&result.Abandoned,
+	)
+	if err == sql.ErrNoRows {
+		exists, err := r.raceExists(ctx, raceID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !exists {
+			return nil, ErrRaceNotFound
+		}
+
+		return nil, ErrResultNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Placings, err = r.listPlacings(ctx, raceID); err != nil {
+		return nil, err
+	}
+
+	if result.Dividends, err = r.listDividends(ctx, raceID); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *racesRepo) listPlacings(ctx context.Context, raceID int64) ([]*racing.Placing, error) {
+	rows, err := r.db.QueryContext(ctx, getRaceQueries()[placingsList], raceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var placings []*racing.Placing
+
+	for rows.Next() {
+		var placing racing.Placing
+
+		if err := rows.Scan(&placing.RunnerId, &placing.SaddleClothNumber, &placing.Position, &placing.Margin); err != nil {
+			return nil, err
+		}
+
+		placings = append(placings, &placing)
+	}
+
+	return placings, rows.Err()
+}
+
+func (r *racesRepo) listDividends(ctx context.Context, raceID int64) ([]*racing.Dividend, error) {
+	rows, err := r.db.QueryContext(ctx, getRaceQueries()[dividendsList], raceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dividends []*racing.Dividend
+
+	for rows.Next() {
+		var (
+			dividend   racing.Dividend
+			selections string
+		)
+
+		if err := rows.Scan(&dividend.BetType, &selections, &dividend.Amount); err != nil {
+			return nil, err
+		}
+
+		// Selections are stored as a JSON array of saddle cloth numbers.
+		if err := json.Unmarshal([]byte(selections), &dividend.Selections); err != nil {
+			return nil, err
+		}
+
+		dividends = append(dividends, &dividend)
+	}
+
+	return dividends, rows.Err()
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"git.neds.sh/matty/entain/racing/proto/racing"
+)
+
+func (r *racesRepo) ListRunners(ctx context.Context, raceID int64) ([]*racing.Runner, error) {
+	exists, err := r.raceExists(ctx, raceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, ErrRaceNotFound
+	}
+
+	runners, err := r.listRunners(ctx, []int64{raceID})
+	if err != nil {
+		return nil, err
+	}
+
+	return runners[raceID], nil
+}
+
+// loadRunners embeds each race's runners, fetching them for every race in a
+// single query.
+func (r *racesRepo) loadRunners(ctx context.Context, races []*racing.Race) error {
+	if len(races) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(races))
+	for _, race := range races {
+		ids = append(ids, race.Id)
+	}
+
+	runners, err := r.listRunners(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, race := range races {
+		race.Runners = runners[race.Id]
+	}
+
+	return nil
+}
+
+// listRunners returns the runners of the given races, keyed by race ID and
+// ordered by saddle cloth number.
+func (r *racesRepo) listRunners(ctx context.Context, raceIDs []int64) (map[int64][]*racing.Runner, error) {
+	query := getRaceQueries()[runnersList] +
+		" WHERE race_id IN (" + strings.Repeat("?,", len(raceIDs)-1) + "?)" +
+		" ORDER BY race_id, saddle_cloth_number"
+
+	args := make([]interface{}, 0, len(raceIDs))
+	for _, id := range raceIDs {
+		args = append(args, id)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return r.scanRunners(rows)
+}
+
+func (r *racesRepo) scanRunners(rows *sql.Rows) (map[int64][]*racing.Runner, error) {
+	runners := make(map[int64][]*racing.Runner)
+
+	for rows.Next() {
+		var runner racing.Runner
+
+		if err := rows.Scan(
+			&runner.Id,
+			&runner.RaceId,
+			&runner.Barrier,
+			&runner.SaddleClothNumber,
+			&runner.Name,
+			&runner.Jockey,
+			&runner.Trainer,
+			&runner.Weight,
+			&runner.Scratched,
+		); err != nil {
+			return nil, err
+		}
+
+		runners[runner.RaceId] = append(runners[runner.RaceId], &runner)
+	}
+
+	return runners, rows.Err()
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the repositories, as children of the span of the
+// RPC being served.
+var tracer = otel.Tracer("git.neds.sh/matty/entain/racing/db")
+
+// rowsReturnedKey records the number of rows a query returned.
+const rowsReturnedKey = attribute.Key("db.response.returned_rows")
+
+// startQuerySpan starts a span for a query against the database, recording its
+// SQL with the whitespace of our query templates collapsed.
+func (db *DB) startQuerySpan(ctx context.Context, name, query string) (context.Context, trace.Span) {
+	system := semconv.DBSystemSqlite
+	if db.dialect.Name() == "postgres" {
+		system = semconv.DBSystemPostgreSQL
+	}
+
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			system,
+			semconv.DBStatementKey.String(strings.Join(strings.Fields(query), " ")),
+		),
+	)
+}
+
+// endSpan ends a span, recording err as its failure if it is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// watchHealth pings the database every interval until ctx is done, reporting
+// the racing service, and the server as a whole, as SERVING only while the
+// database responds.
+func watchHealth(ctx context.Context, racingDB *db.DB, healthServer *health.Server, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := grpc_health_v1.HealthCheckResponse_UNKNOWN
+
+	for {
+		status := grpc_health_v1.HealthCheckResponse_SERVING
+
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := racingDB.PingContext(pingCtx)
+		cancel()
+
+		// Shutdown reports NOT_SERVING itself, so a ping cut short by it is
+		// not a failure.
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			status = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+			log.WithError(err).Error("database ping failed")
+		}
+
+		if status != last {
+			log.WithField("status", status.String()).Info("health status changed")
+			last = status
+		}
+
+		// The overall status is reported under the empty service name.
+		healthServer.SetServingStatus("", status)
+		healthServer.SetServingStatus(racing.Racing_ServiceDesc.ServiceName, status)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const testHealthInterval = 10 * time.Millisecond
+
+// servingStatus returns the status the health server reports for a service.
+func servingStatus(t *testing.T, healthServer *health.Server, service string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	t.Helper()
+
+	resp, err := healthServer.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+	require.NoError(t, err)
+
+	return resp.Status
+}
+
+// startWatchHealth watches the health of a new database until the test ends,
+// returning the database and the health server reporting it.
+func startWatchHealth(t *testing.T) (*db.DB, *health.Server) {
+	t.Helper()
+
+	racingDB, err := db.Open(filepath.Join(t.TempDir(), "racing.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { racingDB.Close() })
+
+	healthServer := health.NewServer()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		watchHealth(ctx, racingDB, healthServer, testHealthInterval)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return racingDB, healthServer
+}
+
+func TestWatchHealth(t *testing.T) {
+	racingDB, healthServer := startWatchHealth(t)
+
+	for _, service := range []string{"", racing.Racing_ServiceDesc.ServiceName} {
+		assert.Eventually(t, func() bool {
+			return servingStatus(t, healthServer, service) == grpc_health_v1.HealthCheckResponse_SERVING
+		}, time.Second, testHealthInterval, "service %q", service)
+	}
+
+	// Pings of a closed database fail.
+	require.NoError(t, racingDB.Close())
+
+	for _, service := range []string{"", racing.Racing_ServiceDesc.ServiceName} {
+		assert.Eventually(t, func() bool {
+			return servingStatus(t, healthServer, service) == grpc_health_v1.HealthCheckResponse_NOT_SERVING
+		}, time.Second, testHealthInterval, "service %q", service)
+	}
+}
+
+func TestWatchHealthShutdown(t *testing.T) {
+	_, healthServer := startWatchHealth(t)
+
+	require.Eventually(t, func() bool {
+		return servingStatus(t, healthServer, racing.Racing_ServiceDesc.ServiceName) == grpc_health_v1.HealthCheckResponse_SERVING
+	}, time.Second, testHealthInterval)
+
+	healthServer.Shutdown()
+
+	// Successful pings made while draining must not report SERVING again.
+	time.Sleep(3 * testHealthInterval)
+
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, servingStatus(t, healthServer, ""))
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, servingStatus(t, healthServer, racing.Racing_ServiceDesc.ServiceName))
+}
@@ -0,0 +1,163 @@
+// Package logging logs the RPCs served by the racing service.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/auth"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// requestIDKey is the metadata key of the ID the gateway assigns each request,
+// which is logged to correlate the logs of the gateway and its backends.
+const requestIDKey = "x-request-id"
+
+// UnaryServerInterceptor logs each unary RPC once it completes. It follows the
+// authorizer's interceptor, so that the principal and filter logged are those
+// the RPC was authorised with.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+		logRPC(ctx, info.FullMethod, req, start, err)
+
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor logs each streaming RPC, such as WatchRaces, once it
+// ends, along with the first message its client sent.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		stream := &recordingStream{ServerStream: ss}
+
+		err := handler(srv, stream)
+		logRPC(ss.Context(), info.FullMethod, stream.req, start, err)
+
+		return err
+	}
+}
+
+// recordingStream records the first message received on a stream.
+type recordingStream struct {
+	grpc.ServerStream
+	req interface{}
+}
+
+func (s *recordingStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil && s.req == nil {
+		s.req = m
+	}
+
+	return err
+}
+
+func logRPC(ctx context.Context, method string, req interface{}, start time.Time, err error) {
+	code := status.Code(err)
+
+	fields := log.Fields{
+		"request_id": requestID(ctx),
+		"method":     method,
+		"duration":   time.Since(start).String(),
+		"code":       code.String(),
+	}
+
+	if subject := auth.FromContext(ctx).Subject; subject != "" {
+		fields["subject"] = subject
+	}
+
+	if filter := filterSummary(req); filter != "" {
+		fields["filter"] = filter
+	}
+
+	if span := trace.SpanContextFromContext(ctx); span.HasTraceID() {
+		fields["trace_id"] = span.TraceID().String()
+	}
+
+	entry := log.WithFields(fields)
+
+	switch {
+	case code == codes.OK && isHealthCheck(method):
+		// Health checks are frequent, so are only of interest when debugging.
+		entry.Debug("rpc completed")
+	case code == codes.OK:
+		entry.Info("rpc completed")
+	case code == codes.Canceled:
+		// Callers cancel, e.g., watch streams once they are done with them.
+		entry.Info("rpc cancelled")
+	case isServerError(code):
+		entry.WithError(err).Error("rpc failed")
+	default:
+		entry.WithError(err).Warn("rpc failed")
+	}
+}
+
+func isHealthCheck(method string) bool {
+	return strings.HasPrefix(method, "/"+healthpb.Health_ServiceDesc.ServiceName+"/")
+}
+
+// isServerError reports whether a code indicates a fault of the server, rather
+// than of the request.
+func isServerError(code codes.Code) bool {
+	switch code {
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+
+	return false
+}
+
+// requestID returns the ID the gateway assigned the request being served, or
+// an empty string if it was not called through the gateway.
+func requestID(ctx context.Context) string {
+	if ids := metadata.ValueFromIncomingContext(ctx, requestIDKey); len(ids) > 0 {
+		return ids[0]
+	}
+
+	return ""
+}
+
+// filterSummary renders the filter field of a request, such as that of
+// ListRaces, as compact JSON, or returns an empty string if it has none.
+func filterSummary(req interface{}) string {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return ""
+	}
+
+	m := msg.ProtoReflect()
+
+	field := m.Descriptor().Fields().ByName("filter")
+	if field == nil || !m.Has(field) {
+		return ""
+	}
+
+	b, err := protojson.Marshal(m.Get(field).Message().Interface())
+	if err != nil {
+		return ""
+	}
+
+	// protojson deliberately varies its whitespace, which is removed so that
+	// summaries of equal filters are equal.
+	var summary bytes.Buffer
+	if err := json.Compact(&summary, b); err != nil {
+		return ""
+	}
+
+	return summary.String()
+}
@@ -1,59 +1,201 @@
 package main
 
 import (
-	"database/sql"
-	"flag"
-	"log"
+	"context"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"git.neds.sh/matty/entain/config"
+	"git.neds.sh/matty/entain/racing/auth"
+	"git.neds.sh/matty/entain/racing/clock"
 	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/logging"
+	"git.neds.sh/matty/entain/racing/metrics"
 	"git.neds.sh/matty/entain/racing/proto/racing"
 	"git.neds.sh/matty/entain/racing/service"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
-)
-
-var (
-	grpcEndpoint = flag.String("grpc-endpoint", "localhost:9000", "gRPC server endpoint")
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
-	flag.Parse()
+	cfg := defaultConfig()
+
+	args, err := config.Load("racing", os.Args[1:], &cfg)
+	if err != nil {
+		if config.IsHelp(err) {
+			return
+		}
 
-	if err := run(); err != nil {
-		log.Fatalf("failed running grpc server: %s\n", err)
+		log.Fatalf("failed loading config: %s", err)
+	}
+
+	if err := config.SetupLogging(cfg.LogLevel, cfg.LogFormat); err != nil {
+		log.Fatalf("failed setting up logging: %s", err)
+	}
+
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrate(cfg, args[1:]); err != nil {
+			log.Fatalf("failed running migrations: %s", err)
+		}
+
+		return
+	}
+
+	if err := run(cfg); err != nil {
+		log.Fatalf("failed running grpc server: %s", err)
 	}
 }
 
-func run() error {
-	conn, err := net.Listen("tcp", ":9000")
+func run(cfg Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	shutdownTracing, err := cfg.Tracing.Start(ctx, "racing")
 	if err != nil {
 		return err
 	}
+	defer config.FlushTracing(shutdownTracing)
 
-	racingDB, err := sql.Open("sqlite3", "./db/racing.db")
+	tlsConfig, err := cfg.TLS.ServerConfig()
 	if err != nil {
 		return err
 	}
 
-	racesRepo := db.NewRacesRepo(racingDB)
+	conn, err := net.Listen("tcp", cfg.GRPCEndpoint)
+	if err != nil {
+		return err
+	}
+
+	racingDB, err := db.Open(cfg.DBDSN)
+	if err != nil {
+		return err
+	}
+	defer racingDB.Close()
+
+	repoOpts := db.RepoOptions{Seed: cfg.Seed}
+
+	// Meetings are initialised first, as races may be filtered by them.
+	meetingsRepo := db.NewMeetingsRepo(racingDB, repoOpts)
+	if err := meetingsRepo.Init(); err != nil {
+		return err
+	}
+
+	clk := clock.New()
+
+	racesRepo := db.NewRacesRepo(racingDB, clk, repoOpts)
 	if err := racesRepo.Init(); err != nil {
 		return err
 	}
 
-	grpcServer := grpc.NewServer()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(racingDB.DB, "racing"),
+		metrics.NewRacesCollector(racesRepo, cfg.QueryTimeout),
+	)
+
+	serverMetrics := metrics.NewServerMetrics(registry)
+	authorizer := auth.NewAuthorizer(cfg.TradingRoles)
+
+	// Requests are authorised after they are traced and counted, so that
+	// rejected requests still are, but before they are logged, so that logs
+	// record the principal and filter they were authorised with. The
+	// authorizer logs those it rejects.
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			otelgrpc.UnaryServerInterceptor(),
+			serverMetrics.UnaryServerInterceptor(),
+			authorizer.UnaryServerInterceptor(),
+			logging.UnaryServerInterceptor(),
+		),
+		grpc.ChainStreamInterceptor(
+			otelgrpc.StreamServerInterceptor(),
+			serverMetrics.StreamServerInterceptor(),
+			authorizer.StreamServerInterceptor(),
+			logging.StreamServerInterceptor(),
+		),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	grpcServer := grpc.NewServer(opts...)
 
 	racing.RegisterRacingServer(
 		grpcServer,
 		service.NewRacingService(
 			racesRepo,
+			meetingsRepo,
+			clk,
+			service.QueryTimeouts{
+				Default: cfg.QueryTimeout,
+				PerRPC:  cfg.RPCQueryTimeouts,
+			},
 		),
 	)
 
-	log.Printf("gRPC server listening on: %s\n", *grpcEndpoint)
+	healthServer := health.NewServer()
+	grpc_health_v1.RegisterHealthServer(grpcServer, healthServer)
+
+	go watchHealth(ctx, racingDB, healthServer, cfg.HealthCheckInterval)
 
-	if err := grpcServer.Serve(conn); err != nil {
+	metricsServer, err := config.ServeMetrics(cfg.MetricsEndpoint, registry)
+	if err != nil {
 		return err
 	}
 
+	// Metrics are served until the drain completes, so that it can be
+	// observed.
+	if metricsServer != nil {
+		defer metricsServer.Close()
+	}
+
+	log.Infof("gRPC server listening on: %s", cfg.GRPCEndpoint)
+
+	served := make(chan error, 1)
+	go func() { served <- grpcServer.Serve(conn) }()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.WithField("drain_timeout", cfg.DrainTimeout.String()).Info("shutting down, draining requests")
+
+	// Report NOT_SERVING for the rest of the drain, so that we are taken out
+	// of rotation while in-flight requests finish.
+	healthServer.Shutdown()
+
+	drain(grpcServer, cfg.DrainTimeout)
+
 	return nil
 }
+
+// drain stops the server gracefully, waiting for in-flight requests to finish,
+// but forcibly closes any remaining (e.g. watch streams) after the timeout.
+func drain(grpcServer *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		log.Warn("drain timed out, closing remaining connections")
+		grpcServer.Stop()
+	}
+}
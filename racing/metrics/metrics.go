@@ -0,0 +1,156 @@
+// Package metrics instruments the racing service for Prometheus.
+package metrics
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// ServerMetrics records the number and latency of the RPCs handled by a gRPC
+// server, by method and status code.
+type ServerMetrics struct {
+	handled  *prometheus.CounterVec
+	handling *prometheus.HistogramVec
+}
+
+// NewServerMetrics creates the metrics of a gRPC server, registering them with
+// reg.
+func NewServerMetrics(reg prometheus.Registerer) *ServerMetrics {
+	m := &ServerMetrics{
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_handled_total",
+			Help: "Total number of RPCs completed by the server, regardless of success or failure.",
+		}, []string{"grpc_type", "grpc_service", "grpc_method", "grpc_code"}),
+		handling: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_server_handling_seconds",
+			Help:    "Time taken by the server to complete RPCs, until the last message of streams.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"grpc_type", "grpc_service", "grpc_method"}),
+	}
+
+	reg.MustRegister(m.handled, m.handling)
+
+	return m
+}
+
+// UnaryServerInterceptor records unary RPCs.
+func (m *ServerMetrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+		m.observe("unary", info.FullMethod, start, err)
+
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor records streaming RPCs, such as WatchRaces, once they
+// end.
+func (m *ServerMetrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+
+		err := handler(srv, ss)
+		m.observe(streamType(info), info.FullMethod, start, err)
+
+		return err
+	}
+}
+
+func (m *ServerMetrics) observe(rpcType, fullMethod string, start time.Time, err error) {
+	service, method := splitMethod(fullMethod)
+
+	m.handled.WithLabelValues(rpcType, service, method, status.Code(err).String()).Inc()
+	m.handling.WithLabelValues(rpcType, service, method).Observe(time.Since(start).Seconds())
+}
+
+func streamType(info *grpc.StreamServerInfo) string {
+	switch {
+	case info.IsClientStream && info.IsServerStream:
+		return "bidi_stream"
+	case info.IsClientStream:
+		return "client_stream"
+	}
+
+	return "server_stream"
+}
+
+// splitMethod splits a full method name, e.g. "/racing.Racing/ListRaces", into
+// its service and method.
+func splitMethod(fullMethod string) (string, string) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return "unknown", "unknown"
+	}
+
+	return service, method
+}
+
+// racesStatuses are the statuses races are counted by: open and closed until
+// they are run, then by their results.
+var racesStatuses = []racing.Race_Status{
+	racing.Race_OPEN,
+	racing.Race_CLOSED,
+	racing.Race_INTERIM,
+	racing.Race_FINAL,
+	racing.Race_ABANDONED,
+}
+
+// racesCollector counts the races of each status as it is scraped, as the
+// status of a race changes with the time rather than on writes.
+type racesCollector struct {
+	repo    db.RacesRepo
+	timeout time.Duration
+	desc    *prometheus.Desc
+}
+
+// NewRacesCollector creates a collector of the number of races of each status,
+// e.g. open and closed, each counted within timeout unless it is zero.
+func NewRacesCollector(repo db.RacesRepo, timeout time.Duration) prometheus.Collector {
+	return &racesCollector{
+		repo:    repo,
+		timeout: timeout,
+		desc: prometheus.NewDesc(
+			"racing_races",
+			"Number of races by status, regardless of their visibility.",
+			[]string{"status"},
+			nil,
+		),
+	}
+}
+
+func (c *racesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *racesCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	for _, s := range racesStatuses {
+		// The total size of a page counts every race matching the filter, so
+		// only a single race need be fetched.
+		page, err := c.repo.List(ctx, &racing.ListRacesRequestFilter{Status: s}, db.ListOptions{PageSize: 1})
+		if err != nil {
+			log.WithError(err).WithField("status", s.String()).Error("failed counting races")
+			ch <- prometheus.NewInvalidMetric(c.desc, err)
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(page.TotalSize), s.String())
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"git.neds.sh/matty/entain/racing/db"
+	log "github.com/sirupsen/logrus"
+)
+
+const migrateUsage = `usage: racing [flags] migrate [-dry-run] <command>
+
+commands:
+  up [version]    apply pending migrations, up to version if given
+  down <version>  revert migrations newer than version (0 reverts all)
+  status          list migrations and whether each is applied`
+
+// runMigrate implements the migrate subcommand, which manages the schema of
+// the racing database without starting the server.
+func runMigrate(cfg Config, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "list the migrations which would run, without running them")
+	flags.Usage = func() { fmt.Fprintln(flags.Output(), migrateUsage) }
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	racingDB, err := db.Open(cfg.DBDSN)
+	if err != nil {
+		return err
+	}
+	defer racingDB.Close()
+
+	migrator, err := db.NewMigrator(racingDB)
+	if err != nil {
+		return err
+	}
+
+	opts := db.MigrateOptions{DryRun: *dryRun}
+
+	switch flags.Arg(0) {
+	case "up":
+		target, err := migrateTarget(flags.Arg(1), false)
+		if err != nil {
+			return err
+		}
+
+		applied, err := migrator.Up(target, opts)
+		if err != nil {
+			return err
+		}
+
+		logMigrations("applied", applied, opts)
+	case "down":
+		target, err := migrateTarget(flags.Arg(1), true)
+		if err != nil {
+			return err
+		}
+
+		reverted, err := migrator.Down(target, opts)
+		if err != nil {
+			return err
+		}
+
+		logMigrations("reverted", reverted, opts)
+	case "status":
+		version, err := migrator.Version()
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrator.Migrations() {
+			state := "pending"
+			if migration.Version <= version {
+				state = "applied"
+			}
+
+			log.WithFields(log.Fields{
+				"version": migration.Version,
+				"name":    migration.Name,
+			}).Info(state)
+		}
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown migrate command %q", flags.Arg(0))
+	}
+
+	return nil
+}
+
+// migrateTarget parses a target version argument, which down requires.
+func migrateTarget(arg string, required bool) (int, error) {
+	if arg == "" {
+		if required {
+			return 0, fmt.Errorf("a target version is required")
+		}
+
+		return 0, nil
+	}
+
+	target, err := strconv.Atoi(arg)
+	if err != nil || target < 0 {
+		return 0, fmt.Errorf("invalid target version %q", arg)
+	}
+
+	return target, nil
+}
+
+func logMigrations(verb string, migrations []db.Migration, opts db.MigrateOptions) {
+	if opts.DryRun {
+		verb = "would have " + verb
+	}
+
+	if len(migrations) == 0 {
+		log.Infof("no migrations %s", verb)
+		return
+	}
+
+	for _, migration := range migrations {
+		log.WithFields(log.Fields{
+			"version": migration.Version,
+			"name":    migration.Name,
+		}).Info(verb)
+	}
+}
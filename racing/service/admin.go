@@ -0,0 +1,317 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+const (
+	// minRaceNumber and maxRaceNumber bound the number of a race at its meeting.
+	minRaceNumber = 1
+	maxRaceNumber = 30
+
+	// maxRaceNameLength bounds the length of a race's name, in characters.
+	maxRaceNameLength = 100
+
+	// maxStartTimeSkew bounds how far from now a race may be advertised to
+	// start, catching typos such as a wrong year.
+	maxStartTimeSkew = 365 * 24 * time.Hour
+
+	// maxBatchSize bounds the number of races created in a single batch.
+	maxBatchSize = 1000
+)
+
+// updatableRaceFields are the fields of a race which UpdateRace may change.
+var updatableRaceFields = []protoreflect.Name{
+	"meeting_id",
+	"name",
+	"number",
+	"visible",
+	"advertised_start_time",
+}
+
+func (s *racingService) CreateRace(ctx context.Context, in *racing.CreateRaceRequest) (*racing.Race, error) {
+	ctx, cancel := s.queryContext(ctx, "CreateRace")
+	defer cancel()
+
+	violations, err := s.raceViolations(ctx, "race", in.Race)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	if len(violations) > 0 {
+		return nil, invalidArgument(violations)
+	}
+
+	races, err := s.racesRepo.Create(ctx, []*racing.Race{in.Race})
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return races[0], nil
+}
+
+func (s *racingService) BatchCreateRaces(ctx context.Context, in *racing.BatchCreateRacesRequest) (*racing.BatchCreateRacesResponse, error) {
+	if len(in.Requests) > maxBatchSize {
+		return nil, invalidArgument([]*errdetails.BadRequest_FieldViolation{{
+			Field:       "requests",
+			Description: fmt.Sprintf("must not contain more than %d requests", maxBatchSize),
+		}})
+	}
+
+	ctx, cancel := s.queryContext(ctx, "BatchCreateRaces")
+	defer cancel()
+
+	var (
+		violations []*errdetails.BadRequest_FieldViolation
+		races      = make([]*racing.Race, 0, len(in.Requests))
+	)
+
+	for i, req := range in.Requests {
+		v, err := s.raceViolations(ctx, fmt.Sprintf("requests[%d].race", i), req.GetRace())
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		violations = append(violations, v...)
+		races = append(races, req.GetRace())
+	}
+
+	if len(violations) > 0 {
+		return nil, invalidArgument(violations)
+	}
+
+	created, err := s.racesRepo.Create(ctx, races)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return &racing.BatchCreateRacesResponse{Races: created}, nil
+}
+
+func (s *racingService) UpdateRace(ctx context.Context, in *racing.UpdateRaceRequest) (*racing.Race, error) {
+	if in.Race == nil {
+		return nil, invalidArgument([]*errdetails.BadRequest_FieldViolation{{
+			Field:       "race",
+			Description: "is required",
+		}})
+	}
+
+	ctx, cancel := s.queryContext(ctx, "UpdateRace")
+	defer cancel()
+
+	race, err := s.racesRepo.Get(ctx, in.Race.Id, db.GetOptions{})
+	if err != nil {
+		if errors.Is(err, db.ErrRaceNotFound) {
+			return nil, status.Errorf(codes.NotFound, "race %d not found", in.Race.Id)
+		}
+
+		return nil, queryError(ctx, err)
+	}
+
+	paths, violations := applyUpdateMask(race, in.Race, in.UpdateMask)
+	if len(violations) > 0 {
+		return nil, invalidArgument(violations)
+	}
+
+	all, err := s.raceViolations(ctx, "race", race)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	// Only the updated fields are validated, so that a race whose existing
+	// values have since become invalid (e.g. an old start time) can still be
+	// corrected field by field.
+	for _, violation := range all {
+		for _, path := range paths {
+			if violation.Field == "race."+string(path) {
+				violations = append(violations, violation)
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return nil, invalidArgument(violations)
+	}
+
+	fields := make([]string, 0, len(paths))
+	for _, path := range paths {
+		fields = append(fields, string(path))
+	}
+
+	// Only the updated fields are written, so that updates of other fields
+	// made since the race was read are kept.
+	updated, err := s.racesRepo.Update(ctx, race, fields)
+	if err != nil {
+		if errors.Is(err, db.ErrRaceNotFound) {
+			return nil, status.Errorf(codes.NotFound, "race %d not found", in.Race.Id)
+		}
+
+		return nil, queryError(ctx, err)
+	}
+
+	return updated, nil
+}
+
+func (s *racingService) DeleteRace(ctx context.Context, in *racing.DeleteRaceRequest) (*emptypb.Empty, error) {
+	ctx, cancel := s.queryContext(ctx, "DeleteRace")
+	defer cancel()
+
+	if err := s.racesRepo.Delete(ctx, in.Id); err != nil {
+		if errors.Is(err, db.ErrRaceNotFound) {
+			return nil, status.Errorf(codes.NotFound, "race %d not found", in.Id)
+		}
+
+		return nil, queryError(ctx, err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// applyUpdateMask copies the fields named by mask from src to dst, returning
+// the fields copied. An empty mask names the updatable fields populated in src,
+// and "*" names them all.
+func applyUpdateMask(
+	dst, src *racing.Race,
+	mask *fieldmaskpb.FieldMask,
+) ([]protoreflect.Name, []*errdetails.BadRequest_FieldViolation) {
+	var (
+		fields     = dst.ProtoReflect().Descriptor().Fields()
+		paths      []protoreflect.Name
+		violations []*errdetails.BadRequest_FieldViolation
+	)
+
+	switch {
+	case len(mask.GetPaths()) == 0:
+		for _, name := range updatableRaceFields {
+			if src.ProtoReflect().Has(fields.ByName(name)) {
+				paths = append(paths, name)
+			}
+		}
+	case len(mask.GetPaths()) == 1 && mask.GetPaths()[0] == "*":
+		paths = updatableRaceFields
+	default:
+		for _, path := range mask.GetPaths() {
+			if !isUpdatableRaceField(protoreflect.Name(path)) {
+				violations = append(violations, &errdetails.BadRequest_FieldViolation{
+					Field:       "update_mask",
+					Description: fmt.Sprintf("field %q cannot be updated", path),
+				})
+				continue
+			}
+
+			paths = append(paths, protoreflect.Name(path))
+		}
+	}
+
+	if len(violations) > 0 {
+		return nil, violations
+	}
+
+	for _, name := range paths {
+		field := fields.ByName(name)
+
+		if src.ProtoReflect().Has(field) {
+			dst.ProtoReflect().Set(field, src.ProtoReflect().Get(field))
+		} else {
+			dst.ProtoReflect().Clear(field)
+		}
+	}
+
+	return paths, nil
+}
+
+func isUpdatableRaceField(name protoreflect.Name) bool {
+	for _, field := range updatableRaceFields {
+		if field == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// raceViolations validates the writable fields of a race, naming each field
+// relative to prefix.
+func (s *racingService) raceViolations(
+	ctx context.Context,
+	prefix string,
+	race *racing.Race,
+) ([]*errdetails.BadRequest_FieldViolation, error) {
+	if race == nil {
+		return []*errdetails.BadRequest_FieldViolation{{
+			Field:       prefix,
+			Description: "is required",
+		}}, nil
+	}
+
+	var violations []*errdetails.BadRequest_FieldViolation
+
+	violate := func(field, description string) {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       prefix + "." + field,
+			Description: description,
+		})
+	}
+
+	if _, err := s.meetingsRepo.Get(ctx, race.MeetingId); err != nil {
+		if !errors.Is(err, db.ErrMeetingNotFound) {
+			return nil, err
+		}
+
+		violate("meeting_id", fmt.Sprintf("meeting %d does not exist", race.MeetingId))
+	}
+
+	switch name := strings.TrimSpace(race.Name); {
+	case name == "":
+		violate("name", "must not be empty")
+	case len([]rune(name)) > maxRaceNameLength:
+		violate("name", fmt.Sprintf("must not be longer than %d characters", maxRaceNameLength))
+	}
+
+	if race.Number < minRaceNumber || race.Number > maxRaceNumber {
+		violate("number", fmt.Sprintf("must be between %d and %d", minRaceNumber, maxRaceNumber))
+	}
+
+	switch start := race.AdvertisedStartTime; {
+	case start == nil:
+		violate("advertised_start_time", "is required")
+	case start.CheckValid() != nil:
+		violate("advertised_start_time", "must be a valid timestamp")
+	case start.Nanos != 0:
+		// Start times are stored to the second, so finer times would not
+		// read back as written.
+		violate("advertised_start_time", "must be a whole number of seconds")
+	default:
+		if skew := start.AsTime().Sub(s.clock.Now()); skew > maxStartTimeSkew || skew < -maxStartTimeSkew {
+			violate("advertised_start_time", "must be within a year of now")
+		}
+	}
+
+	return violations, nil
+}
+
+// invalidArgument builds an InvalidArgument error carrying the violations as
+// BadRequest details.
+func invalidArgument(violations []*errdetails.BadRequest_FieldViolation) error {
+	st := status.New(codes.InvalidArgument, "invalid race")
+
+	detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
@@ -0,0 +1,395 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fieldViolations returns the descriptions of the BadRequest field violations
+// of an InvalidArgument error, by field.
+func fieldViolations(t *testing.T, err error) map[string]string {
+	t.Helper()
+
+	st, ok := status.FromError(err)
+	require.True(t, ok, "not a status: %v", err)
+	require.Equal(t, codes.InvalidArgument, st.Code(), st.Message())
+
+	violations := make(map[string]string)
+
+	for _, detail := range st.Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.FieldViolations {
+				violations[violation.Field] = violation.Description
+			}
+		}
+	}
+
+	require.NotEmpty(t, violations, "no field violations in %v", err)
+
+	return violations
+}
+
+func TestCreateRaceValidation(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		modify func(race *racing.Race)
+		fields []string
+	}{
+		{
+			name:   "unknown meeting",
+			modify: func(race *racing.Race) { race.MeetingId = 2 },
+			fields: []string{"race.meeting_id"},
+		},
+		{
+			name:   "blank name",
+			modify: func(race *racing.Race) { race.Name = "  " },
+			fields: []string{"race.name"},
+		},
+		{
+			name:   "long name",
+			modify: func(race *racing.Race) { race.Name = strings.Repeat("é", maxRaceNameLength+1) },
+			fields: []string{"race.name"},
+		},
+		{
+			name:   "number too low",
+			modify: func(race *racing.Race) { race.Number = minRaceNumber - 1 },
+			fields: []string{"race.number"},
+		},
+		{
+			name:   "number too high",
+			modify: func(race *racing.Race) { race.Number = maxRaceNumber + 1 },
+			fields: []string{"race.number"},
+		},
+		{
+			name:   "no start time",
+			modify: func(race *racing.Race) { race.AdvertisedStartTime = nil },
+			fields: []string{"race.advertised_start_time"},
+		},
+		{
+			name:   "invalid start time",
+			modify: func(race *racing.Race) { race.AdvertisedStartTime.Nanos = -1 },
+			fields: []string{"race.advertised_start_time"},
+		},
+		{
+			name:   "fractional start time",
+			modify: func(race *racing.Race) { race.AdvertisedStartTime.Nanos = 500 },
+			fields: []string{"race.advertised_start_time"},
+		},
+		{
+			name: "start time too far ahead",
+			modify: func(race *racing.Race) {
+				race.AdvertisedStartTime = timestamppb.New(testNow.Add(maxStartTimeSkew + time.Second))
+			},
+			fields: []string{"race.advertised_start_time"},
+		},
+		{
+			name: "start time too far behind",
+			modify: func(race *racing.Race) {
+				race.AdvertisedStartTime = timestamppb.New(testNow.Add(-maxStartTimeSkew - time.Second))
+			},
+			fields: []string{"race.advertised_start_time"},
+		},
+		{
+			name: "several fields",
+			modify: func(race *racing.Race) {
+				race.Name = ""
+				race.Number = 0
+			},
+			fields: []string{"race.name", "race.number"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := newTestService(t)
+
+			race := validRace()
+			tc.modify(race)
+
+			_, err := s.CreateRace(context.Background(), &racing.CreateRaceRequest{Race: race})
+
+			violations := fieldViolations(t, err)
+
+			var fields []string
+			for field := range violations {
+				fields = append(fields, field)
+			}
+
+			assert.ElementsMatch(t, tc.fields, fields)
+		})
+	}
+
+	t.Run("missing race", func(t *testing.T) {
+		s, _ := newTestService(t)
+
+		_, err := s.CreateRace(context.Background(), &racing.CreateRaceRequest{})
+		assert.Contains(t, fieldViolations(t, err), "race")
+	})
+}
+
+func TestCreateRace(t *testing.T) {
+	s, races := newTestService(t)
+
+	created, err := s.CreateRace(context.Background(), &racing.CreateRaceRequest{Race: validRace()})
+	require.NoError(t, err)
+
+	assert.NotZero(t, created.Id)
+	assert.Equal(t, racing.Race_OPEN, created.Status)
+
+	stored, err := races.Get(context.Background(), created.Id, db.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(created, stored))
+}
+
+func TestBatchCreateRacesValidation(t *testing.T) {
+	s, races := newTestService(t)
+
+	invalid := validRace()
+	invalid.Name = ""
+
+	_, err := s.BatchCreateRaces(context.Background(), &racing.BatchCreateRacesRequest{
+		Requests: []*racing.CreateRaceRequest{{Race: validRace()}, {Race: invalid}, {}},
+	})
+
+	violations := fieldViolations(t, err)
+	assert.Contains(t, violations, "requests[1].race.name")
+	assert.Contains(t, violations, "requests[2].race")
+	assert.Len(t, violations, 2)
+
+	// The batch is atomic, so the valid race was not created either.
+	page, err := races.List(context.Background(), nil, db.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, page.Races)
+
+	requests := make([]*racing.CreateRaceRequest, maxBatchSize+1)
+	for i := range requests {
+		requests[i] = &racing.CreateRaceRequest{Race: validRace()}
+	}
+
+	_, err = s.BatchCreateRaces(context.Background(), &racing.BatchCreateRacesRequest{Requests: requests})
+	assert.Contains(t, fieldViolations(t, err), "requests")
+}
+
+func TestApplyUpdateMask(t *testing.T) {
+	existing := func() *racing.Race {
+		race := validRace()
+		race.Id = 5
+		race.Status = racing.Race_OPEN
+
+		return race
+	}
+
+	for _, tc := range []struct {
+		name  string
+		src   *racing.Race
+		mask  []string
+		want  func(race *racing.Race)
+		paths []string
+		err   bool
+	}{
+		{
+			name:  "empty mask updates populated fields",
+			src:   &racing.Race{Id: 5, Name: "Caulfield Cup", Number: 8},
+			want:  func(race *racing.Race) { race.Name, race.Number = "Caulfield Cup", 8 },
+			paths: []string{"name", "number"},
+		},
+		{
+			name:  "empty mask cannot clear fields",
+			src:   &racing.Race{Id: 5, Visible: false},
+			want:  func(*racing.Race) {},
+			paths: nil,
+		},
+		{
+			name:  "named fields are cleared when unset",
+			src:   &racing.Race{Id: 5, Name: "Caulfield Cup"},
+			mask:  []string{"name", "visible"},
+			want:  func(race *racing.Race) { race.Name, race.Visible = "Caulfield Cup", false },
+			paths: []string{"name", "visible"},
+		},
+		{
+			name: "wildcard replaces every field",
+			src:  &racing.Race{Id: 5, Name: "Caulfield Cup"},
+			mask: []string{"*"},
+			want: func(race *racing.Race) {
+				race.MeetingId, race.Name, race.Number, race.Visible, race.AdvertisedStartTime = 0, "Caulfield Cup", 0, false, nil
+			},
+			paths: []string{"meeting_id", "name", "number", "visible", "advertised_start_time"},
+		},
+		{
+			name: "id cannot be updated",
+			src:  &racing.Race{Id: 6},
+			mask: []string{"id"},
+			err:  true,
+		},
+		{
+			name: "status cannot be updated",
+			src:  &racing.Race{Id: 5, Status: racing.Race_FINAL},
+			mask: []string{"name", "status"},
+			err:  true,
+		},
+		{
+			name: "unknown field",
+			src:  &racing.Race{Id: 5},
+			mask: []string{"distance"},
+			err:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := existing()
+
+			var mask *fieldmaskpb.FieldMask
+			if tc.mask != nil {
+				mask = &fieldmaskpb.FieldMask{Paths: tc.mask}
+			}
+
+			paths, violations := applyUpdateMask(dst, tc.src, mask)
+
+			if tc.err {
+				require.NotEmpty(t, violations)
+				assert.Equal(t, "update_mask", violations[0].Field)
+				assert.True(t, proto.Equal(existing(), dst), "race modified despite violations")
+
+				return
+			}
+
+			require.Empty(t, violations)
+
+			var got []string
+			for _, path := range paths {
+				got = append(got, string(path))
+			}
+
+			assert.Equal(t, tc.paths, got)
+
+			want := existing()
+			tc.want(want)
+			assert.True(t, proto.Equal(want, dst), "got %v, want %v", dst, want)
+		})
+	}
+}
+
+// interleavedRacesRepo is a races repository which calls interleave after each
+// race it gets.
+type interleavedRacesRepo struct {
+	db.RacesRepo
+	interleave func()
+}
+
+func (r *interleavedRacesRepo) Get(ctx context.Context, id int64, opts db.GetOptions) (*racing.Race, error) {
+	race, err := r.RacesRepo.Get(ctx, id, opts)
+	r.interleave()
+
+	return race, err
+}
+
+func TestUpdateRace(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("only updated fields are validated", func(t *testing.T) {
+		s, races := newTestService(t)
+
+		// Races may be left with start times which are no longer valid.
+		old := validRace()
+		old.AdvertisedStartTime = timestamppb.New(testNow.Add(-2 * maxStartTimeSkew))
+
+		created, err := races.Create(ctx, []*racing.Race{old})
+		require.NoError(t, err)
+
+		updated, err := s.UpdateRace(ctx, &racing.UpdateRaceRequest{
+			Race:       &racing.Race{Id: created[0].Id, Name: "Caulfield Cup"},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "Caulfield Cup", updated.Name)
+
+		_, err = s.UpdateRace(ctx, &racing.UpdateRaceRequest{
+			Race:       &racing.Race{Id: created[0].Id, Number: 0},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"number"}},
+		})
+		assert.Equal(t, map[string]string{"race.number": "must be between 1 and 30"}, fieldViolations(t, err))
+	})
+
+	t.Run("concurrent updates of other fields are kept", func(t *testing.T) {
+		s, races := newTestService(t)
+
+		created, err := races.Create(ctx, []*racing.Race{validRace()})
+		require.NoError(t, err)
+
+		id := created[0].Id
+
+		// The name is changed after the service reads the race, as though by a
+		// concurrent update.
+		s.racesRepo = &interleavedRacesRepo{RacesRepo: races, interleave: func() {
+			_, err := races.Update(ctx, &racing.Race{Id: id, Name: "Caulfield Cup"}, []string{"name"})
+			require.NoError(t, err)
+		}}
+
+		updated, err := s.UpdateRace(ctx, &racing.UpdateRaceRequest{
+			Race:       &racing.Race{Id: id, Number: 8},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"number"}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "Caulfield Cup", updated.Name)
+		assert.EqualValues(t, 8, updated.Number)
+	})
+
+	t.Run("missing race", func(t *testing.T) {
+		s, _ := newTestService(t)
+
+		_, err := s.UpdateRace(ctx, &racing.UpdateRaceRequest{Race: &racing.Race{Id: 99, Name: "Cup"}})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+
+		_, err = s.UpdateRace(ctx, &racing.UpdateRaceRequest{})
+		assert.Contains(t, fieldViolations(t, err), "race")
+	})
+}
+
+func TestDeleteRace(t *testing.T) {
+	ctx := context.Background()
+	s, races := newTestService(t)
+
+	created, err := races.Create(ctx, []*racing.Race{validRace(), validRace()})
+	require.NoError(t, err)
+
+	deleted, kept := created[0].Id, created[1].Id
+
+	for _, id := range []int64{deleted, kept} {
+		require.NoError(t, races.PutRunners(id, []*racing.Runner{{Id: id * 10, SaddleClothNumber: 1, Name: "Runner"}}))
+		require.NoError(t, races.PutResult(&racing.RaceResult{RaceId: id, Official: true}))
+	}
+
+	_, err = s.DeleteRace(ctx, &racing.DeleteRaceRequest{Id: deleted})
+	require.NoError(t, err)
+
+	_, err = s.DeleteRace(ctx, &racing.DeleteRaceRequest{Id: deleted})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = races.Get(ctx, deleted, db.GetOptions{})
+	assert.ErrorIs(t, err, db.ErrRaceNotFound)
+
+	_, err = races.ListRunners(ctx, deleted)
+	assert.ErrorIs(t, err, db.ErrRaceNotFound)
+
+	_, err = races.GetResult(ctx, deleted)
+	assert.ErrorIs(t, err, db.ErrRaceNotFound)
+
+	// Other races keep their runners and results.
+	runners, err := races.ListRunners(ctx, kept)
+	require.NoError(t, err)
+	assert.Len(t, runners, 1)
+
+	result, err := races.GetResult(ctx, kept)
+	require.NoError(t, err)
+	assert.True(t, result.Official)
+}
@@ -1,31 +1,220 @@
 package service
 
 import (
+	"errors"
+
+	"git.neds.sh/matty/entain/racing/auth"
+	"git.neds.sh/matty/entain/racing/clock"
 	"git.neds.sh/matty/entain/racing/db"
 	"git.neds.sh/matty/entain/racing/proto/racing"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 type Racing interface {
 	// ListRaces will return a collection of races.
 	ListRaces(ctx context.Context, in *racing.ListRacesRequest) (*racing.ListRacesResponse, error)
+
+	// GetRace will return a single race by its ID.
+	GetRace(ctx context.Context, in *racing.GetRaceRequest) (*racing.Race, error)
+
+	// ListRunners will return the runners competing in a race.
+	ListRunners(ctx context.Context, in *racing.ListRunnersRequest) (*racing.ListRunnersResponse, error)
+
+	// ListMeetings will return a collection of meetings.
+	ListMeetings(ctx context.Context, in *racing.ListMeetingsRequest) (*racing.ListMeetingsResponse, error)
+
+	// GetMeeting will return a single meeting by its ID.
+	GetMeeting(ctx context.Context, in *racing.GetMeetingRequest) (*racing.Meeting, error)
+
+	// GetRaceResult will return the result of a race.
+	GetRaceResult(ctx context.Context, in *racing.GetRaceResultRequest) (*racing.RaceResult, error)
+
+	// WatchRaces will stream a snapshot of races, then changes to them.
+	WatchRaces(in *racing.WatchRacesRequest, stream racing.Racing_WatchRacesServer) error
+
+	// CreateRace will create a single race.
+	CreateRace(ctx context.Context, in *racing.CreateRaceRequest) (*racing.Race, error)
+
+	// BatchCreateRaces will create a number of races atomically.
+	BatchCreateRaces(ctx context.Context, in *racing.BatchCreateRacesRequest) (*racing.BatchCreateRacesResponse, error)
+
+	// UpdateRace will update the fields of a race named by a field mask.
+	UpdateRace(ctx context.Context, in *racing.UpdateRaceRequest) (*racing.Race, error)
+
+	// DeleteRace will delete a race.
+	DeleteRace(ctx context.Context, in *racing.DeleteRaceRequest) (*emptypb.Empty, error)
 }
 
 // racingService implements the Racing interface.
 type racingService struct {
-	racesRepo db.RacesRepo
+	racesRepo    db.RacesRepo
+	meetingsRepo db.MeetingsRepo
+	clock        clock.Clock
+	timeouts     QueryTimeouts
+	poller       *racePoller
 }
 
-// NewRacingService instantiates and returns a new racingService.
-func NewRacingService(racesRepo db.RacesRepo) Racing {
-	return &racingService{racesRepo}
+// NewRacingService instantiates and returns a new racingService. The clock is
+// used to sanity check the start times of races being written, and the
+// timeouts bound each RPC's queries. Watched races are polled once for all
+// watchers.
+func NewRacingService(
+	racesRepo db.RacesRepo,
+	meetingsRepo db.MeetingsRepo,
+	clock clock.Clock,
+	timeouts QueryTimeouts,
+) Racing {
+	s := &racingService{
+		racesRepo:    racesRepo,
+		meetingsRepo: meetingsRepo,
+		clock:        clock,
+		timeouts:     timeouts,
+	}
+	s.poller = newRacePoller(s.readSnapshot, watchPollInterval)
+
+	return s
 }
 
 func (s *racingService) ListRaces(ctx context.Context, in *racing.ListRacesRequest) (*racing.ListRacesResponse, error) {
-	races, err := s.racesRepo.List(in.Filter)
+	ctx, cancel := s.queryContext(ctx, "ListRaces")
+	defer cancel()
+
+	page, err := s.racesRepo.List(ctx, in.Filter, db.ListOptions{
+		OrderBy:   in.OrderBy,
+		PageSize:  in.PageSize,
+		PageToken: in.PageToken,
+		View:      in.View,
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidOrderBy) ||
+			errors.Is(err, db.ErrInvalidPageSize) ||
+			errors.Is(err, db.ErrInvalidPageToken) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		return nil, queryError(ctx, err)
+	}
+
+	return &racing.ListRacesResponse{
+		Races:         page.Races,
+		NextPageToken: page.NextPageToken,
+		TotalSize:     page.TotalSize,
+	}, nil
+}
+
+func (s *racingService) GetRace(ctx context.Context, in *racing.GetRaceRequest) (*racing.Race, error) {
+	ctx, cancel := s.queryContext(ctx, "GetRace")
+	defer cancel()
+
+	race, err := s.racesRepo.Get(ctx, in.Id, db.GetOptions{View: in.View})
+	if err != nil {
+		if errors.Is(err, db.ErrRaceNotFound) {
+			return nil, status.Errorf(codes.NotFound, "race %d not found", in.Id)
+		}
+
+		return nil, queryError(ctx, err)
+	}
+
+	if !race.Visible && !auth.CanViewHidden(ctx) {
+		return nil, status.Errorf(codes.NotFound, "race %d not found", in.Id)
+	}
+
+	return race, nil
+}
+
+func (s *racingService) ListRunners(ctx context.Context, in *racing.ListRunnersRequest) (*racing.ListRunnersResponse, error) {
+	ctx, cancel := s.queryContext(ctx, "ListRunners")
+	defer cancel()
+
+	if err := s.checkVisible(ctx, in.RaceId); err != nil {
+		return nil, err
+	}
+
+	runners, err := s.racesRepo.ListRunners(ctx, in.RaceId)
 	if err != nil {
+		if errors.Is(err, db.ErrRaceNotFound) {
+			return nil, status.Errorf(codes.NotFound, "race %d not found", in.RaceId)
+		}
+
+		return nil, queryError(ctx, err)
+	}
+
+	return &racing.ListRunnersResponse{Runners: runners}, nil
+}
+
+func (s *racingService) ListMeetings(ctx context.Context, in *racing.ListMeetingsRequest) (*racing.ListMeetingsResponse, error) {
+	ctx, cancel := s.queryContext(ctx, "ListMeetings")
+	defer cancel()
+
+	meetings, err := s.meetingsRepo.List(ctx, in.Filter)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return &racing.ListMeetingsResponse{Meetings: meetings}, nil
+}
+
+func (s *racingService) GetMeeting(ctx context.Context, in *racing.GetMeetingRequest) (*racing.Meeting, error) {
+	ctx, cancel := s.queryContext(ctx, "GetMeeting")
+	defer cancel()
+
+	meeting, err := s.meetingsRepo.Get(ctx, in.Id)
+	if err != nil {
+		if errors.Is(err, db.ErrMeetingNotFound) {
+			return nil, status.Errorf(codes.NotFound, "meeting %d not found", in.Id)
+		}
+
+		return nil, queryError(ctx, err)
+	}
+
+	return meeting, nil
+}
+
+func (s *racingService) GetRaceResult(ctx context.Context, in *racing.GetRaceResultRequest) (*racing.RaceResult, error) {
+	ctx, cancel := s.queryContext(ctx, "GetRaceResult")
+	defer cancel()
+
+	if err := s.checkVisible(ctx, in.RaceId); err != nil {
 		return nil, err
 	}
 
-	return &racing.ListRacesResponse{Races: races}, nil
+	result, err := s.racesRepo.GetResult(ctx, in.RaceId)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrRaceNotFound):
+			return nil, status.Errorf(codes.NotFound, "race %d not found", in.RaceId)
+		case errors.Is(err, db.ErrResultNotFound):
+			return nil, status.Errorf(codes.NotFound, "race %d has no result", in.RaceId)
+		}
+
+		return nil, queryError(ctx, err)
+	}
+
+	return result, nil
+}
+
+// checkVisible returns NotFound for a race the caller may not see, so that
+// hidden races cannot be told apart from missing ones.
+func (s *racingService) checkVisible(ctx context.Context, id int64) error {
+	if auth.CanViewHidden(ctx) {
+		return nil
+	}
+
+	race, err := s.racesRepo.Get(ctx, id, db.GetOptions{})
+	if err != nil {
+		if errors.Is(err, db.ErrRaceNotFound) {
+			return status.Errorf(codes.NotFound, "race %d not found", id)
+		}
+
+		return queryError(ctx, err)
+	}
+
+	if !race.Visible {
+		return status.Errorf(codes.NotFound, "race %d not found", id)
+	}
+
+	return nil
 }
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/auth"
+	"git.neds.sh/matty/entain/racing/clock"
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// newTestService returns a service over in-memory repositories holding a
+// single meeting, with ID 1.
+func newTestService(t *testing.T) (*racingService, *db.MemoryRacesRepo) {
+	t.Helper()
+
+	meetings := db.NewMemoryMeetingsRepo(&racing.Meeting{
+		Id:       1,
+		Venue:    "Flemington",
+		State:    "VIC",
+		Country:  "AUS",
+		RaceType: racing.Meeting_THOROUGHBRED,
+		Date:     "2021-03-02",
+	})
+	races := db.NewMemoryRacesRepo(meetings, clock.Frozen(testNow))
+
+	return NewRacingService(races, meetings, clock.Frozen(testNow), QueryTimeouts{}).(*racingService), races
+}
+
+// validRace returns a race which passes validation.
+func validRace() *racing.Race {
+	return &racing.Race{
+		MeetingId:           1,
+		Name:                "Melbourne Cup",
+		Number:              7,
+		Visible:             true,
+		AdvertisedStartTime: timestamppb.New(testNow.Add(time.Hour)),
+	}
+}
+
+// authorizedContext returns the context a handler is called with for a
+// request from a principal holding the roles, as authorised by the
+// interceptor the service is served behind.
+func authorizedContext(t *testing.T, roles string) context.Context {
+	t.Helper()
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		auth.SubjectKey, "alice",
+		auth.RolesKey, roles,
+	))
+
+	var authorized context.Context
+
+	_, err := auth.NewAuthorizer([]string{"trader"}).UnaryServerInterceptor()(
+		ctx,
+		&racing.GetRaceRequest{},
+		&grpc.UnaryServerInfo{FullMethod: "/racing.Racing/GetRace"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			authorized = ctx
+			return nil, nil
+		},
+	)
+	require.NoError(t, err)
+
+	return authorized
+}
+
+func TestHiddenRaces(t *testing.T) {
+	s, races := newTestService(t)
+
+	hidden := validRace()
+	hidden.Visible = false
+
+	created, err := races.Create(context.Background(), []*racing.Race{hidden})
+	require.NoError(t, err)
+
+	id := created[0].Id
+	require.NoError(t, races.PutRunners(id, []*racing.Runner{{SaddleClothNumber: 1, Name: "Phar Lap"}}))
+	require.NoError(t, races.PutResult(&racing.RaceResult{RaceId: id, Official: true}))
+
+	rpcs := map[string]func(ctx context.Context) error{
+		"GetRace": func(ctx context.Context) error {
+			_, err := s.GetRace(ctx, &racing.GetRaceRequest{Id: id})
+			return err
+		},
+		"ListRunners": func(ctx context.Context) error {
+			_, err := s.ListRunners(ctx, &racing.ListRunnersRequest{RaceId: id})
+			return err
+		},
+		"GetRaceResult": func(ctx context.Context) error {
+			_, err := s.GetRaceResult(ctx, &racing.GetRaceResultRequest{RaceId: id})
+			return err
+		},
+	}
+
+	for name, rpc := range rpcs {
+		t.Run(name, func(t *testing.T) {
+			// Hidden races are indistinguishable from missing ones to those
+			// who may not see them.
+			err := rpc(context.Background())
+			assert.Equal(t, codes.NotFound, status.Code(err))
+			assert.ErrorContains(t, err, "not found")
+
+			assert.Equal(t, codes.NotFound, status.Code(rpc(authorizedContext(t, "viewer"))))
+
+			assert.NoError(t, rpc(authorizedContext(t, "trader")))
+		})
+	}
+}
+
+func TestGetRace(t *testing.T) {
+	s, races := newTestService(t)
+
+	created, err := races.Create(context.Background(), []*racing.Race{validRace()})
+	require.NoError(t, err)
+
+	race, err := s.GetRace(context.Background(), &racing.GetRaceRequest{Id: created[0].Id})
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(created[0], race), "got %v", race)
+
+	_, err = s.GetRace(context.Background(), &racing.GetRaceRequest{Id: created[0].Id + 1})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.ErrorContains(t, err, "not found")
+}
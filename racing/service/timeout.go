@@ -0,0 +1,52 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// QueryTimeouts bounds how long each RPC may spend querying the repositories.
+// The caller's gRPC deadline still applies when it is sooner.
+type QueryTimeouts struct {
+	// Default applies to RPCs without a timeout of their own. Zero means no
+	// timeout.
+	Default time.Duration
+	// PerRPC overrides Default by method name, e.g. "ListRaces".
+	PerRPC map[string]time.Duration
+}
+
+// queryContext derives the context the named RPC queries the repositories
+// under, applying its timeout.
+func (s *racingService) queryContext(ctx context.Context, rpc string) (context.Context, context.CancelFunc) {
+	timeout, ok := s.timeouts.PerRPC[rpc]
+	if !ok {
+		timeout = s.timeouts.Default
+	}
+
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// queryError converts an error returned by a repository whose context is done
+// into a DeadlineExceeded or Canceled status. Other errors are returned as is.
+func queryError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, "query timed out")
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, "query cancelled")
+	}
+
+	return err
+}
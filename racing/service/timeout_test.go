@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// slowRacesRepo is a races repository whose queries block until their context
+// is done.
+type slowRacesRepo struct {
+	db.RacesRepo
+}
+
+func (slowRacesRepo) List(ctx context.Context, _ *racing.ListRacesRequestFilter, _ db.ListOptions) (*db.RacesPage, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (slowRacesRepo) Get(ctx context.Context, _ int64, _ db.GetOptions) (*racing.Race, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestQueryTimeouts(t *testing.T) {
+	const timeout = 10 * time.Millisecond
+
+	s, _ := newTestService(t)
+	s.racesRepo = slowRacesRepo{}
+
+	listRaces := func(ctx context.Context) error {
+		_, err := s.ListRaces(ctx, &racing.ListRacesRequest{})
+		return err
+	}
+
+	t.Run("per-RPC timeout", func(t *testing.T) {
+		s.timeouts = QueryTimeouts{PerRPC: map[string]time.Duration{"ListRaces": timeout}}
+
+		err := listRaces(context.Background())
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+		assert.ErrorContains(t, err, "query timed out")
+	})
+
+	t.Run("default timeout", func(t *testing.T) {
+		s.timeouts = QueryTimeouts{Default: timeout, PerRPC: map[string]time.Duration{"ListRaces": timeout}}
+
+		_, err := s.GetRace(context.Background(), &racing.GetRaceRequest{Id: 1})
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	})
+
+	t.Run("caller deadline sooner than the timeout", func(t *testing.T) {
+		s.timeouts = QueryTimeouts{Default: time.Hour}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(listRaces(ctx)))
+	})
+
+	t.Run("cancelled caller", func(t *testing.T) {
+		s.timeouts = QueryTimeouts{Default: time.Hour}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(timeout, cancel)
+
+		err := listRaces(ctx)
+		assert.Equal(t, codes.Canceled, status.Code(err))
+		assert.ErrorContains(t, err, "query cancelled")
+	})
+}
+
+func TestQueryError(t *testing.T) {
+	failed := errors.New("failed")
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name     string
+		ctx      context.Context
+		err      error
+		wantCode codes.Code
+	}{
+		{"deadline exceeded", expired, context.DeadlineExceeded, codes.DeadlineExceeded},
+		{"cancelled", cancelled, context.Canceled, codes.Canceled},
+		// Drivers may return errors of their own once the context is done.
+		{"driver error after the deadline", expired, failed, codes.DeadlineExceeded},
+		{"driver error after cancellation", cancelled, failed, codes.Canceled},
+		{"wrapped deadline", context.Background(), fmt.Errorf("query: %w", context.DeadlineExceeded), codes.DeadlineExceeded},
+		{"other error", context.Background(), failed, codes.Unknown},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := queryError(tc.ctx, tc.err)
+			assert.Equal(t, tc.wantCode, status.Code(err))
+
+			if tc.wantCode == codes.Unknown {
+				assert.Same(t, tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"golang.org/x/net/context"
+	"google.golang.org/protobuf/proto"
+)
+
+// watchPollInterval is how often watched races are re-read for changes. As
+// statuses are derived when read, time driven transitions such as OPEN to
+// CLOSED are also picked up within an interval.
+const watchPollInterval = time.Second
+
+func (s *racingService) WatchRaces(in *racing.WatchRacesRequest, stream racing.Racing_WatchRacesServer) error {
+	snapshots, unsubscribe := s.poller.subscribe()
+	defer unsubscribe()
+
+	var races []*racing.Race
+
+	for first := true; ; first = false {
+		var snapshot *racesSnapshot
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case snapshot = <-snapshots:
+		}
+
+		if snapshot.err != nil {
+			return snapshot.err
+		}
+
+		next := snapshot.filter(in.Filter)
+
+		if first {
+			if err := stream.Send(&racing.WatchRacesResponse{
+				Type:  racing.WatchRacesResponse_SNAPSHOT,
+				Races: next,
+			}); err != nil {
+				return err
+			}
+		} else {
+			for _, change := range diffRaces(races, next) {
+				if err := stream.Send(change); err != nil {
+					return err
+				}
+			}
+		}
+
+		races = next
+	}
+}
+
+// racesSnapshot is every race, and the meetings they are held at, as read by
+// a single poll, or the error reading them.
+type racesSnapshot struct {
+	races    []*racing.Race
+	meetings map[int64]*racing.Meeting
+	err      error
+}
+
+// filter returns the races of the snapshot matching a filter, in order. It
+// selects the same races as listing them from the repository would.
+func (s *racesSnapshot) filter(filter *racing.ListRacesRequestFilter) []*racing.Race {
+	if filter == nil {
+		filter = &racing.ListRacesRequestFilter{}
+	}
+
+	meetingIDs := make(map[int64]bool)
+	for _, id := range filter.MeetingIds {
+		meetingIDs[id] = true
+	}
+
+	raceTypes := make(map[racing.Meeting_RaceType]bool)
+	for _, raceType := range filter.RaceTypes {
+		raceTypes[raceType] = true
+	}
+
+	venues := make(map[string]bool)
+	for _, venue := range filter.Venues {
+		venues[venue] = true
+	}
+
+	// Like the repository, races of unknown meetings only match filters which
+	// don't reference meetings.
+	matchesMeeting := func(id int64) bool {
+		if len(raceTypes) == 0 && len(venues) == 0 {
+			return true
+		}
+
+		meeting, ok := s.meetings[id]
+
+		switch {
+		case !ok:
+			return false
+		case len(raceTypes) > 0 && !raceTypes[meeting.RaceType]:
+			return false
+		case len(venues) > 0 && !venues[meeting.Venue]:
+			return false
+		}
+
+		return true
+	}
+
+	races := []*racing.Race{}
+
+	for _, race := range s.races {
+		switch {
+		case len(meetingIDs) > 0 && !meetingIDs[race.MeetingId]:
+		case filter.Visible != nil && race.Visible != filter.GetVisible():
+		case filter.Status != racing.Race_STATUS_UNSPECIFIED && race.Status != filter.Status:
+		case !matchesMeeting(race.MeetingId):
+		default:
+			races = append(races, race)
+		}
+	}
+
+	return races
+}
+
+// racePoller reads every race once per interval while races are watched, and
+// fans each snapshot out to the watchers, which filter it themselves. This
+// keeps the load watching puts on the repositories constant, however many
+// watchers there are.
+type racePoller struct {
+	read     func(ctx context.Context) *racesSnapshot
+	interval time.Duration
+
+	mu          sync.Mutex
+	subscribers map[chan *racesSnapshot]bool
+	latest      *racesSnapshot
+	stop        context.CancelFunc
+}
+
+// newRacePoller creates a poller reading races with read. It polls only while
+// it has subscribers.
+func newRacePoller(read func(ctx context.Context) *racesSnapshot, interval time.Duration) *racePoller {
+	return &racePoller{
+		read:        read,
+		interval:    interval,
+		subscribers: make(map[chan *racesSnapshot]bool),
+	}
+}
+
+// subscribe returns a channel receiving each snapshot from the next poll on,
+// or the latest if there's been one. A subscriber which falls behind receives
+// only the latest snapshot. unsubscribe must be called once done.
+func (p *racePoller) subscribe() (snapshots <-chan *racesSnapshot, unsubscribe func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ch := make(chan *racesSnapshot, 1)
+	p.subscribers[ch] = true
+
+	if p.latest != nil {
+		ch <- p.latest
+	}
+
+	if p.stop == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		p.stop = cancel
+
+		go p.poll(ctx)
+	}
+
+	return ch, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		delete(p.subscribers, ch)
+
+		if len(p.subscribers) == 0 && p.stop != nil {
+			p.stop()
+			p.stop = nil
+			p.latest = nil
+		}
+	}
+}
+
+// poll reads races immediately, then every interval, until ctx is done.
+func (p *racePoller) poll(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	// The loop checks ctx itself, as the select may pick a tick which became
+	// ready along with stopping.
+	for ctx.Err() == nil {
+		p.publish(ctx, p.read(ctx))
+
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+}
+
+// publish sends a snapshot to every subscriber, replacing any it has yet to
+// receive, unless the poll was stopped while reading it.
+func (p *racePoller) publish(ctx context.Context, snapshot *racesSnapshot) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Stopping happens under the lock, so can't race with the check.
+	if ctx.Err() != nil {
+		return
+	}
+
+	// A failed read ends the watches, so isn't kept for later subscribers.
+	if snapshot.err == nil {
+		p.latest = snapshot
+	}
+
+	for ch := range p.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+
+		ch <- snapshot
+	}
+}
+
+// readSnapshot reads every race, and the meetings they are held at. The
+// WatchRaces timeout bounds each read, rather than the whole stream.
+func (s *racingService) readSnapshot(ctx context.Context) *racesSnapshot {
+	ctx, cancel := s.queryContext(ctx, "WatchRaces")
+	defer cancel()
+
+	races, err := s.listAllRaces(ctx)
+	if err != nil {
+		return &racesSnapshot{err: queryError(ctx, err)}
+	}
+
+	meetings, err := s.meetingsRepo.List(ctx, nil)
+	if err != nil {
+		return &racesSnapshot{err: queryError(ctx, err)}
+	}
+
+	snapshot := &racesSnapshot{
+		races:    races,
+		meetings: make(map[int64]*racing.Meeting, len(meetings)),
+	}
+
+	for _, meeting := range meetings {
+		snapshot.meetings[meeting.Id] = meeting
+	}
+
+	return snapshot
+}
+
+// listAllRaces returns every race, across all pages.
+func (s *racingService) listAllRaces(ctx context.Context) ([]*racing.Race, error) {
+	var (
+		races []*racing.Race
+		opts  = db.ListOptions{PageSize: db.MaxPageSize}
+	)
+
+	for {
+		page, err := s.racesRepo.List(ctx, nil, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		races = append(races, page.Races...)
+
+		if page.NextPageToken == "" {
+			return races, nil
+		}
+
+		opts.PageToken = page.NextPageToken
+	}
+}
+
+// diffRaces describes the changes from prev to next as a message per kind of
+// change, omitting kinds with no races.
+func diffRaces(prev, next []*racing.Race) []*racing.WatchRacesResponse {
+	var (
+		added   = &racing.WatchRacesResponse{Type: racing.WatchRacesResponse_ADDED}
+		updated = &racing.WatchRacesResponse{Type: racing.WatchRacesResponse_UPDATED}
+		removed = &racing.WatchRacesResponse{Type: racing.WatchRacesResponse_REMOVED}
+		seen    = make(map[int64]*racing.Race, len(prev))
+	)
+
+	for _, race := range prev {
+		seen[race.Id] = race
+	}
+
+	for _, race := range next {
+		old, ok := seen[race.Id]
+		switch {
+		case !ok:
+			added.Races = append(added.Races, race)
+		case !proto.Equal(old, race):
+			updated.Races = append(updated.Races, race)
+		}
+
+		delete(seen, race.Id)
+	}
+
+	// Iterate prev rather than the map, to keep removals in order.
+	for _, race := range prev {
+		if _, ok := seen[race.Id]; ok {
+			removed.Races = append(removed.Races, race)
+		}
+	}
+
+	var changes []*racing.WatchRacesResponse
+
+	for _, change := range []*racing.WatchRacesResponse{added, updated, removed} {
+		if len(change.Races) > 0 {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,342 @@
+package service
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/racing/clock"
+	"git.neds.sh/matty/entain/racing/db"
+	"git.neds.sh/matty/entain/racing/proto/racing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestDiffRaces(t *testing.T) {
+	race := func(id int64, name string, status racing.Race_Status) *racing.Race {
+		return &racing.Race{Id: id, MeetingId: 1, Name: name, Status: status}
+	}
+
+	for _, tc := range []struct {
+		name       string
+		prev, next []*racing.Race
+		want       []*racing.WatchRacesResponse
+	}{
+		{
+			name: "unchanged",
+			prev: []*racing.Race{race(1, "a", racing.Race_OPEN), race(2, "b", racing.Race_OPEN)},
+			next: []*racing.Race{race(1, "a", racing.Race_OPEN), race(2, "b", racing.Race_OPEN)},
+		},
+		{
+			name: "both empty",
+		},
+		{
+			name: "added",
+			prev: []*racing.Race{race(1, "a", racing.Race_OPEN)},
+			next: []*racing.Race{race(3, "c", racing.Race_OPEN), race(1, "a", racing.Race_OPEN), race(2, "b", racing.Race_OPEN)},
+			want: []*racing.WatchRacesResponse{
+				{Type: racing.WatchRacesResponse_ADDED, Races: []*racing.Race{race(3, "c", racing.Race_OPEN), race(2, "b", racing.Race_OPEN)}},
+			},
+		},
+		{
+			name: "updated",
+			prev: []*racing.Race{race(1, "a", racing.Race_OPEN), race(2, "b", racing.Race_OPEN)},
+			next: []*racing.Race{race(1, "a", racing.Race_OPEN), race(2, "renamed", racing.Race_OPEN)},
+			want: []*racing.WatchRacesResponse{
+				{Type: racing.WatchRacesResponse_UPDATED, Races: []*racing.Race{race(2, "renamed", racing.Race_OPEN)}},
+			},
+		},
+		{
+			name: "status flipped",
+			prev: []*racing.Race{race(1, "a", racing.Race_OPEN), race(2, "b", racing.Race_CLOSED)},
+			next: []*racing.Race{race(1, "a", racing.Race_CLOSED), race(2, "b", racing.Race_FINAL)},
+			want: []*racing.WatchRacesResponse{
+				{Type: racing.WatchRacesResponse_UPDATED, Races: []*racing.Race{race(1, "a", racing.Race_CLOSED), race(2, "b", racing.Race_FINAL)}},
+			},
+		},
+		{
+			name: "removed",
+			prev: []*racing.Race{race(1, "a", racing.Race_OPEN), race(2, "b", racing.Race_OPEN), race(3, "c", racing.Race_OPEN)},
+			next: []*racing.Race{race(2, "b", racing.Race_OPEN)},
+			want: []*racing.WatchRacesResponse{
+				{Type: racing.WatchRacesResponse_REMOVED, Races: []*racing.Race{race(1, "a", racing.Race_OPEN), race(3, "c", racing.Race_OPEN)}},
+			},
+		},
+		{
+			name: "all kinds, in order",
+			prev: []*racing.Race{race(1, "a", racing.Race_OPEN), race(2, "b", racing.Race_OPEN)},
+			next: []*racing.Race{race(2, "b", racing.Race_CLOSED), race(3, "c", racing.Race_OPEN)},
+			want: []*racing.WatchRacesResponse{
+				{Type: racing.WatchRacesResponse_ADDED, Races: []*racing.Race{race(3, "c", racing.Race_OPEN)}},
+				{Type: racing.WatchRacesResponse_UPDATED, Races: []*racing.Race{race(2, "b", racing.Race_CLOSED)}},
+				{Type: racing.WatchRacesResponse_REMOVED, Races: []*racing.Race{race(1, "a", racing.Race_OPEN)}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := diffRaces(tc.prev, tc.next)
+
+			require.Len(t, got, len(tc.want))
+			for i := range tc.want {
+				assert.True(t, proto.Equal(tc.want[i], got[i]), "got %v, want %v", got[i], tc.want[i])
+			}
+		})
+	}
+}
+
+// testNow is the frozen time the tests' races start around.
+var testNow = time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)
+
+// newWatchTestService returns a service over in-memory repositories holding
+// three meetings and races of several statuses, polling watched races every
+// interval.
+func newWatchTestService(t *testing.T, interval time.Duration) (*racingService, *db.MemoryRacesRepo) {
+	t.Helper()
+
+	meetings := db.NewMemoryMeetingsRepo(
+		&racing.Meeting{Id: 1, Venue: "Flemington", RaceType: racing.Meeting_THOROUGHBRED, Date: "2021-03-02"},
+		&racing.Meeting{Id: 2, Venue: "Menangle", RaceType: racing.Meeting_HARNESS, Date: "2021-03-02"},
+		&racing.Meeting{Id: 3, Venue: "Sandown Park", RaceType: racing.Meeting_GREYHOUND, Date: "2021-03-02"},
+	)
+	races := db.NewMemoryRacesRepo(meetings, clock.Frozen(testNow))
+
+	s := NewRacingService(races, meetings, clock.Frozen(testNow), QueryTimeouts{}).(*racingService)
+	s.poller = newRacePoller(s.readSnapshot, interval)
+
+	_, err := races.Create(context.Background(), []*racing.Race{
+		{MeetingId: 1, Name: "Final", Number: 1, Visible: true, AdvertisedStartTime: timestamppb.New(testNow.Add(-time.Hour))},
+		{MeetingId: 1, Name: "Closed", Number: 2, Visible: true, AdvertisedStartTime: timestamppb.New(testNow)},
+		{MeetingId: 2, Name: "Hidden", Number: 1, AdvertisedStartTime: timestamppb.New(testNow.Add(time.Hour))},
+		{MeetingId: 3, Name: "Open", Number: 1, Visible: true, AdvertisedStartTime: timestamppb.New(testNow.Add(2 * time.Hour))},
+		{MeetingId: 4, Name: "Unknown meeting", Number: 1, Visible: true, AdvertisedStartTime: timestamppb.New(testNow.Add(3 * time.Hour))},
+	})
+	require.NoError(t, err)
+	require.NoError(t, races.PutResult(&racing.RaceResult{RaceId: 1, Official: true}))
+
+	return s, races
+}
+
+// TestRacesSnapshotFilter checks that watchers select the same races from a
+// snapshot as listing them from the repository does.
+func TestRacesSnapshotFilter(t *testing.T) {
+	ctx := context.Background()
+	s, races := newWatchTestService(t, time.Hour)
+
+	snapshot := s.readSnapshot(ctx)
+	require.NoError(t, snapshot.err)
+
+	visible, hidden := true, false
+
+	for name, filter := range map[string]*racing.ListRacesRequestFilter{
+		"none":               nil,
+		"empty":              {},
+		"meeting ids":        {MeetingIds: []int64{1, 4}},
+		"visible":            {Visible: &visible},
+		"hidden":             {Visible: &hidden},
+		"open":               {Status: racing.Race_OPEN},
+		"closed":             {Status: racing.Race_CLOSED},
+		"final":              {Status: racing.Race_FINAL},
+		"race types":         {RaceTypes: []racing.Meeting_RaceType{racing.Meeting_HARNESS, racing.Meeting_GREYHOUND}},
+		"venues":             {Venues: []string{"Flemington"}},
+		"unknown venue":      {Venues: []string{"Randwick"}},
+		"types and venues":   {RaceTypes: []racing.Meeting_RaceType{racing.Meeting_THOROUGHBRED}, Venues: []string{"Menangle"}},
+		"visible and status": {Visible: &visible, Status: racing.Race_OPEN},
+	} {
+		page, err := races.List(ctx, filter, db.ListOptions{PageSize: db.MaxPageSize})
+		require.NoError(t, err)
+
+		var want []int64
+		for _, race := range page.Races {
+			want = append(want, race.Id)
+		}
+
+		var got []int64
+		for _, race := range snapshot.filter(filter) {
+			got = append(got, race.Id)
+		}
+
+		assert.Equal(t, want, got, name)
+	}
+}
+
+// countingRacesRepo counts the races listed through it.
+type countingRacesRepo struct {
+	db.RacesRepo
+	lists int64
+}
+
+func (r *countingRacesRepo) List(ctx context.Context, filter *racing.ListRacesRequestFilter, opts db.ListOptions) (*db.RacesPage, error) {
+	atomic.AddInt64(&r.lists, 1)
+	return r.RacesRepo.List(ctx, filter, opts)
+}
+
+func TestRacePoller(t *testing.T) {
+	s, _ := newWatchTestService(t, 10*time.Millisecond)
+
+	repo := &countingRacesRepo{RacesRepo: s.racesRepo}
+	s.racesRepo = repo
+
+	// Each read signals it has started, then waits to be released, so that
+	// polls can be stepped through one at a time. A poll has published once
+	// the next has started.
+	started, release := make(chan struct{}), make(chan struct{})
+	poller := newRacePoller(func(ctx context.Context) *racesSnapshot {
+		select {
+		case started <- struct{}{}:
+		case <-ctx.Done():
+			return &racesSnapshot{err: ctx.Err()}
+		}
+
+		select {
+		case release <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		return s.readSnapshot(ctx)
+	}, time.Millisecond)
+
+	step := func() {
+		t.Helper()
+
+		<-release
+
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("not polled")
+		}
+	}
+
+	first, unsubscribeFirst := poller.subscribe()
+	second, unsubscribeSecond := poller.subscribe()
+
+	<-started
+	step()
+
+	a, b := <-first, <-second
+	require.NoError(t, a.err)
+	assert.Same(t, a, b, "subscribers share a snapshot")
+	assert.EqualValues(t, 1, atomic.LoadInt64(&repo.lists))
+
+	// A later subscriber receives the latest snapshot without a read.
+	third, unsubscribeThird := poller.subscribe()
+	assert.Same(t, a, <-third)
+	assert.EqualValues(t, 1, atomic.LoadInt64(&repo.lists))
+
+	// A subscriber which falls behind receives only the latest snapshot.
+	step()
+	c := <-first
+	step()
+
+	d := <-first
+	assert.NotSame(t, c, d)
+	assert.Same(t, d, <-second)
+	assert.Same(t, d, <-third)
+	assert.EqualValues(t, 3, atomic.LoadInt64(&repo.lists))
+
+	unsubscribeFirst()
+	unsubscribeSecond()
+	unsubscribeThird()
+
+	// Polling stops with the last subscriber.
+	select {
+	case <-started:
+		t.Fatal("polled without subscribers")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// watchStream records the responses sent on a WatchRaces stream.
+type watchStream struct {
+	grpc.ServerStream
+	ctx       context.Context
+	responses chan *racing.WatchRacesResponse
+}
+
+func (s *watchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *watchStream) Send(response *racing.WatchRacesResponse) error {
+	s.responses <- response
+	return nil
+}
+
+func TestWatchRaces(t *testing.T) {
+	s, races := newWatchTestService(t, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	visible := true
+	stream := &watchStream{ctx: ctx, responses: make(chan *racing.WatchRacesResponse, 10)}
+	done := make(chan error, 1)
+
+	go func() {
+		done <- s.WatchRaces(&racing.WatchRacesRequest{
+			Filter: &racing.ListRacesRequestFilter{Visible: &visible, MeetingIds: []int64{1, 3}},
+		}, stream)
+	}()
+
+	receive := func() *racing.WatchRacesResponse {
+		t.Helper()
+
+		select {
+		case response := <-stream.responses:
+			return response
+		case <-time.After(5 * time.Second):
+			t.Fatal("no response")
+			return nil
+		}
+	}
+
+	ids := func(response *racing.WatchRacesResponse) []int64 {
+		var ids []int64
+		for _, race := range response.Races {
+			ids = append(ids, race.Id)
+		}
+
+		return ids
+	}
+
+	snapshot := receive()
+	assert.Equal(t, racing.WatchRacesResponse_SNAPSHOT, snapshot.Type)
+	assert.Equal(t, []int64{1, 2, 4}, ids(snapshot))
+
+	// Changes to races outside the filter are not sent.
+	_, err := races.Create(ctx, []*racing.Race{
+		{MeetingId: 2, Name: "Other meeting", Number: 2, Visible: true, AdvertisedStartTime: timestamppb.New(testNow.Add(time.Hour))},
+		{MeetingId: 3, Name: "Added", Number: 2, Visible: true, AdvertisedStartTime: timestamppb.New(testNow.Add(time.Hour))},
+	})
+	require.NoError(t, err)
+
+	added := receive()
+	assert.Equal(t, racing.WatchRacesResponse_ADDED, added.Type)
+	assert.Equal(t, []int64{7}, ids(added))
+
+	require.NoError(t, races.PutResult(&racing.RaceResult{RaceId: 2, Abandoned: true}))
+
+	updated := receive()
+	assert.Equal(t, racing.WatchRacesResponse_UPDATED, updated.Type)
+	assert.Equal(t, []int64{2}, ids(updated))
+	assert.Equal(t, racing.Race_ABANDONED, updated.Races[0].Status)
+
+	require.NoError(t, races.Delete(ctx, 1))
+
+	removed := receive()
+	assert.Equal(t, racing.WatchRacesResponse_REMOVED, removed.Type)
+	assert.Equal(t, []int64{1}, ids(removed))
+
+	cancel()
+	require.NoError(t, <-done)
+
+	select {
+	case response := <-stream.responses:
+		t.Fatalf("unexpected response %v", response)
+	default:
+	}
+}
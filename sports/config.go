@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"git.neds.sh/matty/entain/config"
+)
+
+// Config is the configuration of the sports service. See config.Load for how
+// it is set.
+type Config struct {
+	GRPCEndpoint string     `config:"grpc-endpoint" usage:"gRPC server endpoint"`
+	TLS          config.TLS `config:"tls"`
+	DBPath       string     `config:"db-path" usage:"path of the SQLite sports database"`
+	LogLevel     string     `config:"log-level" usage:"minimum level of logs to write: debug, info, warn or error"`
+	LogFormat    string     `config:"log-format" usage:"format of logs: text or json"`
+}
+
+// defaultConfig returns the configuration used where nothing else is set.
+func defaultConfig() Config {
+	return Config{
+		GRPCEndpoint: "localhost:9001",
+		DBPath:       "./db/sports.db",
+		LogLevel:     "info",
+		LogFormat:    "text",
+	}
+}
+
+func (c *Config) Validate() error {
+	if c.GRPCEndpoint == "" {
+		return errors.New("grpc-endpoint is required")
+	}
+
+	if err := c.TLS.ValidateServer(); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+
+	if c.DBPath == "" {
+		return errors.New("db-path is required")
+	}
+
+	if err := config.ValidateLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+
+	return config.ValidateLogFormat(c.LogFormat)
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	"syreclabs.com/go/faker"
+)
+
+// competitions maps each seeded sport onto the competitions it is played in.
+var competitions = map[string][]string{
+	"Soccer":     {"A-League", "Premier League", "Champions League"},
+	"Basketball": {"NBL", "NBA"},
+	"Rugby":      {"NRL", "Super Rugby"},
+	"Cricket":    {"Big Bash League", "Sheffield Shield"},
+	"Tennis":     {"Australian Open", "Wimbledon"},
+}
+
+func (r *eventsRepo) seed() error {
+	if _, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS events (id INTEGER PRIMARY KEY, name TEXT, advertised_start_time DATETIME, sport TEXT, competition TEXT, participants TEXT)`); err != nil {
+		return err
+	}
+
+	statement, err := r.db.Prepare(`INSERT OR IGNORE INTO events(id, name, advertised_start_time, sport, competition, participants) VALUES (?,?,?,?,?,?)`)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	sports := make([]string, 0, len(competitions))
+	for sport := range competitions {
+		sports = append(sports, sport)
+	}
+
+	for i := 1; i <= 100; i++ {
+		sport := faker.RandomChoice(sports)
+		home, away := faker.Team().Name(), faker.Team().Name()
+
+		participants, err := json.Marshal([]string{home, away})
+		if err != nil {
+			return err
+		}
+
+		if _, err := statement.Exec(
+			i,
+			home+" vs "+away,
+			faker.Time().Between(time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 2)).Format(time.RFC3339),
+			sport,
+			faker.RandomChoice(competitions[sport]),
+			string(participants),
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
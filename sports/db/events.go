@@ -0,0 +1,128 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"git.neds.sh/matty/entain/sports/proto/sports"
+	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// EventsRepo provides repository access to sports events.
+type EventsRepo interface {
+	// Init will initialise our events repository.
+	Init() error
+
+	// List will return a list of events, ordered by advertised start time.
+	List(filter *sports.ListEventsRequestFilter) ([]*sports.Event, error)
+}
+
+type eventsRepo struct {
+	db   *sql.DB
+	init sync.Once
+}
+
+// NewEventsRepo creates a new events repository.
+func NewEventsRepo(db *sql.DB) EventsRepo {
+	return &eventsRepo{db: db}
+}
+
+// Init prepares the events repository dummy data.
+func (r *eventsRepo) Init() error {
+	var err error
+
+	r.init.Do(func() {
+		// For test/example purposes, we seed the DB with some dummy events.
+		err = r.seed()
+	})
+
+	return err
+}
+
+func (r *eventsRepo) List(filter *sports.ListEventsRequestFilter) ([]*sports.Event, error) {
+	var (
+		err   error
+		query string
+		args  []interface{}
+	)
+
+	query = getEventQueries()[eventsList]
+
+	query, args = r.applyFilter(query, filter)
+
+	query += " ORDER BY advertised_start_time, id"
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return r.scanEvents(rows)
+}
+
+func (r *eventsRepo) applyFilter(query string, filter *sports.ListEventsRequestFilter) (string, []interface{}) {
+	var (
+		clauses []string
+		args    []interface{}
+	)
+
+	if filter == nil {
+		return query, args
+	}
+
+	if len(filter.Sports) > 0 {
+		clauses = append(clauses, "sport IN ("+strings.Repeat("?,", len(filter.Sports)-1)+"?)")
+
+		for _, sport := range filter.Sports {
+			args = append(args, sport)
+		}
+	}
+
+	if len(filter.Competitions) > 0 {
+		clauses = append(clauses, "competition IN ("+strings.Repeat("?,", len(filter.Competitions)-1)+"?)")
+
+		for _, competition := range filter.Competitions {
+			args = append(args, competition)
+		}
+	}
+
+	if len(clauses) != 0 {
+		query += " WHERE " + strings.Join(clauses, " AND ")
+	}
+
+	return query, args
+}
+
+func (r *eventsRepo) scanEvents(
+	rows *sql.Rows,
+) ([]*sports.Event, error) {
+	var events []*sports.Event
+
+	for rows.Next() {
+		var (
+			event           sports.Event
+			advertisedStart time.Time
+			participants    string
+		)
+
+		if err := rows.Scan(&event.Id, &event.Name, &advertisedStart, &event.Sport, &event.Competition, &participants); err != nil {
+			return nil, err
+		}
+
+		// Participants are stored as a JSON array of names.
+		if err := json.Unmarshal([]byte(participants), &event.Participants); err != nil {
+			return nil, err
+		}
+
+		event.AdvertisedStartTime = timestamppb.New(advertisedStart)
+
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"git.neds.sh/matty/entain/sports/proto/sports"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openTestDB opens an empty in-memory database, which is closed when the test
+// ends.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	// Each connection to :memory: is a database of its own.
+	db.SetMaxOpenConns(1)
+
+	return db
+}
+
+// newTestEventsRepo returns a repository over an in-memory database holding
+// only the given events, as (id, name, advertised_start_time, sport,
+// competition, participants) rows.
+func newTestEventsRepo(t *testing.T, events ...[]interface{}) EventsRepo {
+	t.Helper()
+
+	db := openTestDB(t)
+
+	repo := NewEventsRepo(db)
+	require.NoError(t, repo.Init())
+
+	_, err := db.Exec(`DELETE FROM events`)
+	require.NoError(t, err)
+
+	for _, event := range events {
+		_, err := db.Exec(`INSERT INTO events(id, name, advertised_start_time, sport, competition, participants) VALUES (?,?,?,?,?,?)`, event...)
+		require.NoError(t, err)
+	}
+
+	return repo
+}
+
+func TestEventsRepoSeed(t *testing.T) {
+	repo := NewEventsRepo(openTestDB(t))
+	require.NoError(t, repo.Init())
+
+	events, err := repo.List(nil)
+	require.NoError(t, err)
+	require.Len(t, events, 100)
+
+	for _, event := range events {
+		assert.Contains(t, competitions[event.Sport], event.Competition)
+		assert.Len(t, event.Participants, 2)
+	}
+}
+
+func TestEventsRepoList(t *testing.T) {
+	repo := newTestEventsRepo(t,
+		[]interface{}{1, "Sydney FC vs Melbourne City", "2021-03-02T12:00:00Z", "Soccer", "A-League", `["Sydney FC","Melbourne City"]`},
+		[]interface{}{2, "Kings vs Bullets", "2021-03-02T10:00:00Z", "Basketball", "NBL", `["Kings","Bullets"]`},
+		[]interface{}{3, "Arsenal vs Chelsea", "2021-03-02T11:00:00Z", "Soccer", "Premier League", `["Arsenal","Chelsea"]`},
+		[]interface{}{4, "Lakers vs Celtics", "2021-03-02T10:00:00Z", "Basketball", "NBA", `["Lakers","Celtics"]`},
+	)
+
+	for _, tc := range []struct {
+		name    string
+		filter  *sports.ListEventsRequestFilter
+		wantIDs []int64
+	}{
+		{
+			name:    "no filter",
+			wantIDs: []int64{2, 4, 3, 1},
+		},
+		{
+			name:    "empty filter",
+			filter:  &sports.ListEventsRequestFilter{},
+			wantIDs: []int64{2, 4, 3, 1},
+		},
+		{
+			name:    "sport",
+			filter:  &sports.ListEventsRequestFilter{Sports: []string{"Soccer"}},
+			wantIDs: []int64{3, 1},
+		},
+		{
+			name:    "competitions",
+			filter:  &sports.ListEventsRequestFilter{Competitions: []string{"NBA", "A-League"}},
+			wantIDs: []int64{4, 1},
+		},
+		{
+			name:    "sport and competition",
+			filter:  &sports.ListEventsRequestFilter{Sports: []string{"Soccer"}, Competitions: []string{"A-League", "NBL"}},
+			wantIDs: []int64{1},
+		},
+		{
+			name:   "no matches",
+			filter: &sports.ListEventsRequestFilter{Sports: []string{"Tennis"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			events, err := repo.List(tc.filter)
+			require.NoError(t, err)
+
+			var ids []int64
+			for _, event := range events {
+				ids = append(ids, event.Id)
+			}
+
+			assert.Equal(t, tc.wantIDs, ids)
+		})
+	}
+}
+
+func TestEventsRepoListFields(t *testing.T) {
+	repo := newTestEventsRepo(t,
+		[]interface{}{1, "Sydney FC vs Melbourne City", "2021-03-02T12:00:00Z", "Soccer", "A-League", `["Sydney FC","Melbourne City"]`},
+	)
+
+	events, err := repo.List(nil)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	event := events[0]
+	assert.Equal(t, "Sydney FC vs Melbourne City", event.Name)
+	assert.Equal(t, "2021-03-02T12:00:00Z", event.AdvertisedStartTime.AsTime().Format(time.RFC3339))
+	assert.Equal(t, "Soccer", event.Sport)
+	assert.Equal(t, "A-League", event.Competition)
+	assert.Equal(t, []string{"Sydney FC", "Melbourne City"}, event.Participants)
+}
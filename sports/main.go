@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql"
+	"net"
+	"os"
+
+	"git.neds.sh/matty/entain/config"
+	"git.neds.sh/matty/entain/sports/db"
+	"git.neds.sh/matty/entain/sports/proto/sports"
+	"git.neds.sh/matty/entain/sports/service"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func main() {
+	cfg := defaultConfig()
+
+	if _, err := config.Load("sports", os.Args[1:], &cfg); err != nil {
+		if config.IsHelp(err) {
+			return
+		}
+
+		log.Fatalf("failed loading config: %s", err)
+	}
+
+	if err := config.SetupLogging(cfg.LogLevel, cfg.LogFormat); err != nil {
+		log.Fatalf("failed setting up logging: %s", err)
+	}
+
+	if err := run(cfg); err != nil {
+		log.Fatalf("failed running grpc server: %s", err)
+	}
+}
+
+func run(cfg Config) error {
+	tlsConfig, err := cfg.TLS.ServerConfig()
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.Listen("tcp", cfg.GRPCEndpoint)
+	if err != nil {
+		return err
+	}
+
+	sportsDB, err := sql.Open("sqlite3", cfg.DBPath)
+	if err != nil {
+		return err
+	}
+
+	eventsRepo := db.NewEventsRepo(sportsDB)
+	if err := eventsRepo.Init(); err != nil {
+		return err
+	}
+
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	grpcServer := grpc.NewServer(opts...)
+
+	sports.RegisterSportsServer(
+		grpcServer,
+		service.NewSportsService(
+			eventsRepo,
+		),
+	)
+
+	log.Infof("gRPC server listening on: %s", cfg.GRPCEndpoint)
+
+	if err := grpcServer.Serve(conn); err != nil {
+		return err
+	}
+
+	return nil
+}